@@ -0,0 +1,272 @@
+package excel_to_gorm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// records of a model with a children field, grouped from the rows of a sheet by their key columns
+type rowGroups struct {
+	field   int        // index in Fields of the children field
+	child   *sheetPlan // reads the children from the same rows
+	keyCols []int
+	records map[string]int // key -> index of the record, or skippedGroup
+	last    int            // the group of the row above, for rows with empty keys
+}
+
+const (
+	noGroup      = -2
+	skippedGroup = -1 // the record was left out, eg. by onerror:skip, so its children are too
+)
+
+// the struct type of the elements of a children field, eg. Tree for []Tree or []*Tree.  nil if it is not one
+func childStructType(typ reflect.Type) reflect.Type {
+	if typ.Kind() != reflect.Slice {
+		return nil
+	}
+	elem := typ.Elem()
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		return nil
+	}
+	return elem
+}
+
+// binds the child model of a children field read from the same rows as the model, if there is one
+func (sp *sheetPlan) bindChildren(src SheetSource, hdgRow []CellValue) error {
+	plan := sp.plan
+	fldIx := -1
+	for _, ix := range plan.Children {
+		if plan.Fields[ix].Tag.JoinField != "" {
+			continue
+		}
+		if fldIx >= 0 {
+			return errors.New("only one children field of " + plan.Type.Name() + " can be read from the same sheet. use children:<Field>=<ChildField> for the others")
+		}
+		fldIx = ix
+	}
+	if fldIx < 0 {
+		return nil
+	}
+	fp := &plan.Fields[fldIx]
+	if plan.HasMelt || plan.HasIntCols {
+		return errors.New("field " + fp.Name + " is tagged children, which cannot be combined with melt: or intcols:")
+	}
+
+	childPlan, err := planFor(childStructType(fp.Type))
+	if err != nil {
+		return err
+	}
+	for _, childFp := range childPlan.Fields {
+		if childFp.Tag.ForeignKey != nil {
+			return errors.New("field " + childFp.Name + " of the children in " + fp.Name + " has a fk: tag, which is not supported in children")
+		}
+	}
+	child, err := childPlan.bind(src, hdgRow, sp.params, sp.csvParams)
+	if err != nil {
+		return fmt.Errorf("could not read children of field %v: %w", fp.Name, err)
+	}
+
+	group := &rowGroups{field: fldIx, child: child, records: make(map[string]int), last: noGroup}
+	for ix, f := range plan.Fields {
+		if f.Tag.IsKey && sp.sources[ix] == srcColumn {
+			group.keyCols = append(group.keyCols, sp.cols[ix])
+		}
+	}
+	if group.keyCols == nil {
+		for ix := range plan.Fields {
+			if sp.sources[ix] == srcColumn {
+				group.keyCols = append(group.keyCols, sp.cols[ix])
+			}
+		}
+	}
+	if group.keyCols == nil {
+		return errors.New("field " + fp.Name + " is tagged children, but " + plan.Type.Name() + " has no fields read from columns to group rows by")
+	}
+	sp.group = group
+	return nil
+}
+
+// the key of the record a row belongs to.  blank if all its key cells are empty
+func (g *rowGroups) key(src SheetSource, row int) (key string, blank bool) {
+	parts := make([]string, len(g.keyCols))
+	blank = true
+	for i, col := range g.keyCols {
+		parts[i] = strings.TrimSpace(src.Cell(row, col).Display())
+		if parts[i] != "" {
+			blank = false
+		}
+	}
+	return strings.Join(parts, "\x00"), blank
+}
+
+// adds the children read from a row to the record with the same key, creating the record if it is the first
+func (sp *sheetPlan) appendGrouped(objSlice reflect.Value, src SheetSource, row int) (reflect.Value, error) {
+	g := sp.group
+	key, blank := g.key(src, row)
+	idx, found := g.records[key]
+	if blank && g.last != noGroup {
+		idx, found = g.last, true
+	}
+	if !found {
+		sp.nextRecord = objSlice.Len()
+		pending := len(sp.fkPending)
+		record, err := sp.buildRecord(src, row, colHeading{}, colHeading{})
+		switch {
+		case err == errSkipRecord:
+			sp.fkPending = sp.fkPending[:pending]
+			idx = skippedGroup
+		case err != nil:
+			return objSlice, err
+		default:
+			objSlice = reflect.Append(objSlice, record)
+			idx = objSlice.Len() - 1
+		}
+		g.records[key] = idx
+	}
+	g.last = idx
+	if idx == skippedGroup || g.child.rowIsEmpty(src, row) {
+		return objSlice, nil
+	}
+
+	children := reflect.MakeSlice(reflect.SliceOf(g.child.plan.Type), 0, g.child.recordsPerRow())
+	children, err := g.child.appendRecords(children, src, row)
+	if err != nil {
+		return objSlice, err
+	}
+	field := fieldByIndex(objSlice.Index(idx), sp.plan.Fields[g.field].Index)
+	field.Set(appendChildren(field, children))
+	return objSlice, nil
+}
+
+// whether all the cells the plan reads from columns are empty, so a row holds no child
+func (sp *sheetPlan) rowIsEmpty(src SheetSource, row int) bool {
+	if sp.plan.HasMelt || sp.plan.HasIntCols {
+		return false
+	}
+	for ix, source := range sp.sources {
+		if source != srcColumn {
+			continue
+		}
+		if cv := src.Cell(row, sp.cols[ix]); cv.Kind != CellEmpty || cv.Formula != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// appends records to a children field, which may hold structs or pointers to them
+func appendChildren(field reflect.Value, children reflect.Value) reflect.Value {
+	if field.Type().Elem().Kind() != reflect.Ptr {
+		return reflect.AppendSlice(field, children)
+	}
+	for i := 0; i < children.Len(); i++ {
+		child := reflect.New(children.Type().Elem())
+		child.Elem().Set(children.Index(i))
+		field = reflect.Append(field, child)
+	}
+	return field
+}
+
+// AttachChildren adds records read from another sheet to the children:<Field>=<ChildField> field of the
+// records they belong to.  parents is a slice of the model, as returned by WorksheetToSlice, and is
+// updated in place.  children is a slice of the child model.  Children without a parent are an error
+func AttachChildren(parents interface{}, children interface{}) error {
+	parentSlice := reflect.Indirect(reflect.ValueOf(parents))
+	childSlice := reflect.Indirect(reflect.ValueOf(children))
+	if parentSlice.Kind() != reflect.Slice || childSlice.Kind() != reflect.Slice {
+		return errors.New("AttachChildren needs slices of parents and children")
+	}
+	plan, err := planFor(parentSlice.Type().Elem())
+	if err != nil {
+		return err
+	}
+	var fp *FieldPlan
+	for _, ix := range plan.Children {
+		f := &plan.Fields[ix]
+		if f.Tag.JoinField != "" && childStructType(f.Type) == childSlice.Type().Elem() {
+			fp = f
+		}
+	}
+	if fp == nil {
+		return fmt.Errorf("%v has no children:<Field>=<ChildField> field holding %v", plan.Type, childSlice.Type().Elem())
+	}
+
+	parentIx := make(map[string]int, parentSlice.Len())
+	for i := parentSlice.Len() - 1; i >= 0; i-- {
+		key, err := joinKey(parentSlice.Index(i), fp.Tag.JoinField)
+		if err != nil {
+			return err
+		}
+		parentIx[key] = i
+	}
+	var orphans []string
+	for i := 0; i < childSlice.Len(); i++ {
+		key, err := joinKey(childSlice.Index(i), fp.Tag.JoinChildField)
+		if err != nil {
+			return err
+		}
+		ix, ok := parentIx[key]
+		if !ok {
+			orphans = append(orphans, key)
+			continue
+		}
+		field := fieldByIndex(parentSlice.Index(ix), fp.Index)
+		field.Set(appendChildren(field, childSlice.Slice(i, i+1)))
+	}
+	if len(orphans) > 0 {
+		return fmt.Errorf("%v children of field %v have no parent with %v: %v", len(orphans), fp.Name, fp.Tag.JoinField, strings.Join(orphans, ", "))
+	}
+	return nil
+}
+
+// the value of the field joining a record to its parent or children, as text
+func joinKey(record reflect.Value, fieldName string) (string, error) {
+	field := reflect.Indirect(record).FieldByName(fieldName)
+	if !field.IsValid() {
+		return "", errors.New(record.Type().String() + " has no field " + fieldName)
+	}
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			return "", nil
+		}
+		field = field.Elem()
+	}
+	return fmt.Sprint(field.Interface()), nil
+}
+
+// ToSliceWithChildren reads a sheet into a slice of the model, along with the children of its
+// children:<Field>=<ChildField> fields.  childSheets maps the names of those fields to the sheets holding them
+func (w *Workbook) ToSliceWithChildren(sheetName string, model interface{}, childSheets map[string]string, params Params) (interface{}, error) {
+	parents, err := w.ToSlice(sheetName, model, params)
+	if err != nil {
+		return parents, err
+	}
+	plan, err := PlanFor(model)
+	if err != nil {
+		return parents, err
+	}
+	for fieldName, childSheet := range childSheets {
+		var fp *FieldPlan
+		for _, ix := range plan.Children {
+			if plan.Fields[ix].Name == fieldName && plan.Fields[ix].Tag.JoinField != "" {
+				fp = &plan.Fields[ix]
+			}
+		}
+		if fp == nil {
+			return parents, errors.New(fieldName + " is not a children:<Field>=<ChildField> field of " + plan.Type.Name())
+		}
+		children, err := w.ToSlice(childSheet, reflect.New(childStructType(fp.Type)).Interface(), params)
+		if err != nil {
+			return parents, err
+		}
+		if err := AttachChildren(parents, children); err != nil {
+			return parents, fmt.Errorf("sheet: %v. %w", childSheet, err)
+		}
+	}
+	return parents, nil
+}
@@ -0,0 +1,144 @@
+package excel_to_gorm
+
+import (
+	"bytes"
+	"testing"
+)
+
+type Orchard struct {
+	ID      uint
+	Name    string   `xtg:"col:Orchard,key" gorm:"uniqueIndex"`
+	Owner   string   `xtg:"col:Owner"`
+	RunID   uint     `xtg:"meta:run"`
+	Trees   []Tree   `xtg:"children"`
+	Pickers []Picker `xtg:"children:Name=Orchard"`
+}
+
+type Tree struct {
+	ID        uint
+	OrchardID uint
+	Variety   string `xtg:"col:Variety"`
+	Age       int    `xtg:"col:Age"`
+}
+
+type Picker struct {
+	ID        uint
+	OrchardID uint
+	Orchard   string `xtg:"col:Orchard"`
+	Name      string `xtg:"col:Picker"`
+}
+
+func orchardSheets() map[string][][]interface{} {
+	return map[string][][]interface{}{
+		"trees": {
+			{"Orchard", "Owner", "Variety", "Age"},
+			{"North", "Sam", "Gala", 3},
+			{nil, nil, "Fuji", 4},
+			{"South", "Kim", "Jazz", 1},
+			{"North", "Sam", "Braeburn", 2},
+			{"East", "Lee", nil, nil},
+		},
+		"pickers": {{"Orchard", "Picker"}, {"South", "Ann"}, {"North", "Bo"}, {"South", "Cy"}},
+	}
+}
+
+func TestGroupedChildren(t *testing.T) {
+	wb := mkBook(t, orchardSheets())
+	out, err := WorksheetToSlice(wb.Sheet["trees"], &Orchard{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]Orchard)
+	if len(got) != 3 || got[0].Name != "North" || got[1].Name != "South" || got[2].Name != "East" {
+		t.Fatalf("got %+v", got)
+	}
+	// rows with empty key cells belong to the orchard above, and rows without a tree add none
+	if trees := got[0].Trees; len(trees) != 3 || trees[1].Variety != "Fuji" || trees[2] != (Tree{Variety: "Braeburn", Age: 2}) {
+		t.Errorf("got trees %+v", trees)
+	}
+	if len(got[1].Trees) != 1 || len(got[2].Trees) != 0 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestToSliceWithChildren(t *testing.T) {
+	data := mkBytes(t, orchardSheets())
+	wb, err := OpenReader(bytes.NewReader(data), int64(len(data)), "orchards.xlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wb.Close()
+	out, err := wb.ToSliceWithChildren("trees", &Orchard{}, map[string]string{"Pickers": "pickers"}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]Orchard)
+	if len(got[0].Pickers) != 1 || got[0].Pickers[0].Name != "Bo" || len(got[1].Pickers) != 2 || len(got[2].Pickers) != 0 {
+		t.Errorf("got %+v", got)
+	}
+
+	if _, err := wb.ToSliceWithChildren("trees", &Orchard{}, map[string]string{"Trees": "pickers"}, Params{}); err == nil {
+		t.Error("expected an error for a children field without <Field>=<ChildField>")
+	}
+	orphans := []Picker{{Orchard: "West", Name: "Di"}}
+	if err := AttachChildren(out, orphans); err == nil {
+		t.Error("expected an error for children without a parent")
+	}
+}
+
+func TestImportChildrenAndUndo(t *testing.T) {
+	db := openTestDB(t, &Orchard{}, &Tree{})
+	data := mkBytes(t, orchardSheets())
+	run, err := ImportBytes(db, "orchards.xlsx", data, "trees", &Orchard{}, Params{}, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.RecordsCreated != 7 {
+		t.Errorf("got %v records created, expected 3 orchards and 4 trees", run.RecordsCreated)
+	}
+	var trees []Tree
+	db.Order("id").Find(&trees)
+	if len(trees) != 4 || trees[0].OrchardID != 1 || trees[3].Variety != "Jazz" || trees[3].OrchardID != 2 {
+		t.Errorf("got %+v", trees)
+	}
+
+	if err := UndoImport(db, run.ID); err != nil {
+		t.Fatal(err)
+	}
+	var orchards, treeCount int64
+	db.Model(&Orchard{}).Count(&orchards)
+	db.Model(&Tree{}).Count(&treeCount)
+	if orchards != 0 || treeCount != 0 {
+		t.Errorf("got %v orchards and %v trees after undo", orchards, treeCount)
+	}
+}
+
+func TestUpsertChildrenAndUndo(t *testing.T) {
+	db := openTestDB(t, &Orchard{}, &Tree{})
+	db.Create(&Orchard{Name: "North", Owner: "Pat"})
+	data := mkBytes(t, orchardSheets())
+	run, err := ImportBytes(db, "orchards.xlsx", data, "trees", &Orchard{}, Params{}, ImportOptions{UpsertOn: []string{"name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.RecordsCreated != 6 || run.RecordsUpdated != 1 {
+		t.Errorf("got %v records created and %v updated, expected 2 orchards and 4 trees created and 1 orchard updated",
+			run.RecordsCreated, run.RecordsUpdated)
+	}
+	var north Orchard
+	db.Preload("Trees").Where("name = ?", "North").First(&north)
+	if north.ID != 1 || north.Owner != "Sam" || len(north.Trees) != 3 {
+		t.Errorf("got %+v", north)
+	}
+
+	if err := UndoImport(db, run.ID); err != nil {
+		t.Fatal(err)
+	}
+	var orchards []Orchard
+	var treeCount int64
+	db.Find(&orchards)
+	db.Model(&Tree{}).Count(&treeCount)
+	if len(orchards) != 1 || orchards[0].Owner != "Pat" || orchards[0].RunID != 0 || treeCount != 0 {
+		t.Errorf("got %+v and %v trees after undo", orchards, treeCount)
+	}
+}
@@ -0,0 +1,266 @@
+package excel_to_gorm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// candidates for the delimiter of a CSV file when Params.CSVDelimiter is not set
+const csvDelimiters = ",;\t|"
+
+// converts CSV (or TSV) data into a slice of the model, using the same xtg tags as WorksheetToSlice.
+// The delimiter, quote and encoding are set by Params.CSVDelimiter, CSVQuote and CSVEncoding.
+// Cells which parse as numbers are treated as number cells, the rest as text
+func CSVToSlice(r io.Reader, model interface{}, params Params) (interface{}, error) {
+	modelTyp := reflect.ValueOf(model).Elem().Type()
+	objSlice := reflect.Zero(reflect.SliceOf(modelTyp))
+
+	src, err := NewCSVSource(r, params)
+	if err != nil {
+		return objSlice.Interface(), err
+	}
+	return SourceToSlice(src, model, params)
+}
+
+// a CSV file read a record at a time.  Only the current row can be read by Cell
+type csvSource struct {
+	name   string
+	reader *csvReader
+	record []string
+	rowNum int
+	maxCol int
+	// the first record is read when the source is created, so the width of the data is known
+	first    []string
+	firstErr error
+	err      error
+}
+
+// NewCSVSource adapts CSV data, read a record at a time as the source is iterated.  Its rows can only be read once
+func NewCSVSource(r io.Reader, params Params) (SheetSource, error) {
+	decoder := unicode.BOMOverride(unicode.UTF8.NewDecoder())
+	if params.CSVEncoding != "" {
+		enc, err := htmlindex.Get(params.CSVEncoding)
+		if err != nil {
+			return nil, fmt.Errorf("unknown CSV encoding: %v. %w", params.CSVEncoding, err)
+		}
+		// a byte order mark overrides the encoding, as it does in browsers
+		decoder = unicode.BOMOverride(enc.NewDecoder())
+	}
+	br := bufio.NewReader(transform.NewReader(r, decoder))
+
+	quote := params.CSVQuote
+	if quote == 0 {
+		quote = '"'
+	}
+	delimiter := params.CSVDelimiter
+	if delimiter == 0 {
+		delimiter = guessDelimiter(br, quote)
+	}
+	if delimiter == quote || delimiter == '\r' || delimiter == '\n' {
+		return nil, fmt.Errorf("invalid CSV delimiter %q", delimiter)
+	}
+
+	name := "CSV"
+	if params.FileName != "" {
+		name = strings.TrimSuffix(filepath.Base(params.FileName), filepath.Ext(params.FileName))
+	}
+	src := &csvSource{name: name, reader: &csvReader{r: br, delimiter: delimiter, quote: quote}, rowNum: -1}
+	src.first, src.firstErr = src.reader.read()
+	src.maxCol = len(src.first)
+	return src, nil
+}
+
+// picks the most common delimiter outside quotes in the first line, without consuming it
+func guessDelimiter(br *bufio.Reader, quote rune) rune {
+	// the first line may be longer than the buffer, in which case the start of it will do
+	line, _ := br.Peek(br.Size())
+	if i := strings.IndexByte(string(line), '\n'); i >= 0 {
+		line = line[:i]
+	}
+	best, bestCount := ',', 0
+	for _, delim := range csvDelimiters {
+		count := 0
+		inQuote := false
+		for _, ch := range string(line) {
+			switch {
+			case ch == quote:
+				inQuote = !inQuote
+			case ch == delim && !inQuote:
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = delim, count
+		}
+	}
+	return best
+}
+
+func (s *csvSource) Name() string {
+	return s.name
+}
+
+func (s *csvSource) MaxCol() int {
+	return s.maxCol
+}
+
+// the rows of a CSV file can only be read once
+func (s *csvSource) Rows() RowIterator {
+	return s
+}
+
+func (s *csvSource) Next() bool {
+	if s.err != nil {
+		return false
+	}
+	var record []string
+	var err error
+	if s.rowNum < 0 {
+		record, err = s.first, s.firstErr
+	} else {
+		record, err = s.reader.read()
+	}
+	if err != nil {
+		if err != io.EOF {
+			s.err = fmt.Errorf("could not read CSV line %v: %w", s.reader.line, err)
+		} else {
+			s.err = io.EOF
+		}
+		s.record = nil
+		return false
+	}
+	s.rowNum++
+	s.record = record
+	if len(record) > s.maxCol {
+		s.maxCol = len(record)
+	}
+	return true
+}
+
+func (s *csvSource) Row() int {
+	return s.rowNum
+}
+
+func (s *csvSource) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
+
+// CSV has no merged cells
+func (s *csvSource) MergedCells() []MergedRange {
+	return nil
+}
+
+func (s *csvSource) Cell(row, col int) CellValue {
+	if row != s.rowNum || col < 0 || col >= len(s.record) {
+		return CellValue{}
+	}
+	return csvCellValue(s.record[col])
+}
+
+// a field of a CSV file.  Numbers are told apart from text, as numeric cells are in a workbook
+func csvCellValue(field string) CellValue {
+	if field == "" {
+		return CellValue{}
+	}
+	cv := CellValue{Kind: CellString, Value: field, Formatted: field, fromText: true}
+	// ParseFloat also reads NaN and Inf, which are words rather than numbers in a CSV file
+	if f, err := strconv.ParseFloat(field, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		cv.Kind = CellNumber
+	}
+	return cv
+}
+
+// reads records of RFC 4180 CSV, with any delimiter and quote.  encoding/csv only allows " to quote.
+// A negative quote turns quoting off.  Quotes inside unquoted fields are read as they are
+type csvReader struct {
+	r         *bufio.Reader
+	delimiter rune
+	quote     rune
+	line      int // of the last record read, starting at 1
+	nextLine  int
+}
+
+func (cr *csvReader) read() ([]string, error) {
+	cr.nextLine++
+	cr.line = cr.nextLine
+	var record []string
+	var field strings.Builder
+	inQuotes := false
+	quoted := false // the field started with a quote
+	for {
+		ch, _, err := cr.r.ReadRune()
+		if err == io.EOF {
+			if inQuotes {
+				return nil, errors.New("quoted field is not closed")
+			}
+			if record == nil && field.Len() == 0 && !quoted {
+				return nil, io.EOF
+			}
+			return append(record, field.String()), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if inQuotes {
+			if ch != cr.quote {
+				if ch == '\n' {
+					cr.nextLine++
+				}
+				field.WriteRune(ch)
+				continue
+			}
+			// a doubled quote is a quote, otherwise it closes the field
+			next, _, err := cr.r.ReadRune()
+			if err == nil && next == cr.quote {
+				field.WriteRune(cr.quote)
+				continue
+			}
+			if err == nil {
+				cr.r.UnreadRune()
+			}
+			inQuotes = false
+			continue
+		}
+		switch ch {
+		case cr.delimiter:
+			record = append(record, field.String())
+			field.Reset()
+			quoted = false
+		case '\r':
+			// \r\n ends a record, a lone \r is kept
+			next, _, err := cr.r.ReadRune()
+			if err == nil && next == '\n' {
+				return append(record, field.String()), nil
+			}
+			if err == nil {
+				cr.r.UnreadRune()
+			}
+			field.WriteRune(ch)
+		case '\n':
+			return append(record, field.String()), nil
+		case cr.quote:
+			if field.Len() == 0 && !quoted {
+				inQuotes = true
+				quoted = true
+				continue
+			}
+			field.WriteRune(ch)
+		default:
+			field.WriteRune(ch)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package excel_to_gorm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+type csvYield struct {
+	Name    string  `xtg:"col:Name"`
+	Product string  `xtg:"mapConst:product"`
+	Year    int     `xtg:"intcols:colname"`
+	Yield   float64 `xtg:"intcols:value"`
+	Row     int     `xtg:"meta:row"`
+}
+
+var csvConsts = map[string]string{"product": "apple"}
+
+func TestCSVToSlice(t *testing.T) {
+	in := "Name;2019;2020\r\n\"Honey;crisp\";1.5;2\nGala;3;4\n"
+	out, err := CSVToSlice(strings.NewReader(in), &csvYield{}, Params{ConstMap: csvConsts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]csvYield)
+	want := []csvYield{
+		{"Honey;crisp", "apple", 2019, 1.5, 2}, {"Honey;crisp", "apple", 2020, 2, 2},
+		{"Gala", "apple", 2019, 3, 3}, {"Gala", "apple", 2020, 4, 3},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("record %v: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+type csvPest struct {
+	Name  string  `xtg:"col:Name"`
+	Cause string  `xtg:"melt:colname"`
+	Loss  float64 `xtg:"melt:value"`
+}
+
+func TestCSVDelimiterAndQuote(t *testing.T) {
+	in := "Name\tScab\tBlight\n'Honey\tcrisp'\t1.5\t2\n"
+	out, err := CSVToSlice(strings.NewReader(in), &csvPest{}, Params{CSVQuote: '\''})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]csvPest)
+	if len(got) != 2 || got[0] != (csvPest{"Honey\tcrisp", "Scab", 1.5}) || got[1] != (csvPest{"Honey\tcrisp", "Blight", 2}) {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestCSVEncodings(t *testing.T) {
+	type named struct {
+		Name string `xtg:"col:Name"`
+	}
+	win, _ := charmap.Windows1252.NewEncoder().String("Name\nCafé\n")
+	utf16, _ := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().String("Name\nCafé\n")
+	tests := []struct {
+		in       string
+		encoding string
+	}{
+		{win, "windows-1252"},
+		{utf16, ""},                // found from the byte order mark
+		{"\ufeffName\nCafé\n", ""}, // a UTF-8 byte order mark
+	}
+	for _, tt := range tests {
+		out, err := CSVToSlice(bytes.NewReader([]byte(tt.in)), &named{}, Params{CSVEncoding: tt.encoding})
+		if err != nil {
+			t.Errorf("encoding %q: %v", tt.encoding, err)
+			continue
+		}
+		if got := out.([]named); len(got) != 1 || got[0].Name != "Café" {
+			t.Errorf("encoding %q: got %+v", tt.encoding, got)
+		}
+	}
+	if _, err := CSVToSlice(strings.NewReader("Name\n"), &named{}, Params{CSVEncoding: "klingon"}); err == nil {
+		t.Error("expected an error for an unknown encoding")
+	}
+}
+
+func TestCSVUnterminatedQuote(t *testing.T) {
+	if _, err := CSVToSlice(strings.NewReader("Name,2019\n\"open,1\n"), &csvYield{}, Params{ConstMap: csvConsts}); err == nil {
+		t.Error("expected an error for an unterminated quote")
+	}
+}
+
+func TestCSVCellValue(t *testing.T) {
+	tests := []struct {
+		field string
+		kind  CellKind
+	}{
+		{"", CellEmpty},
+		{"1.5", CellNumber},
+		{"-2e3", CellNumber},
+		{"Gala", CellString},
+		{"NaN", CellString},
+		{"Inf", CellString},
+		{"-infinity", CellString},
+	}
+	for _, tt := range tests {
+		if got := csvCellValue(tt.field); got.Kind != tt.kind {
+			t.Errorf("csvCellValue(%q).Kind = %v, want %v", tt.field, got.Kind, tt.kind)
+		}
+	}
+}
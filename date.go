@@ -0,0 +1,86 @@
+package excel_to_gorm
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// layouts of dates written as text which are read whatever Params.DateLayouts says, as they cannot be
+// mistaken for anything else.  Dates such as 04/03/2021 need a layout, as the day may come first or second
+var isoDateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	time.RFC3339Nano,
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// the location of times without a time zone
+func paramsLocation(params Params) *time.Location {
+	if params.Location == nil {
+		return time.UTC
+	}
+	return params.Location
+}
+
+// the same wall clock time in another location, as serial dates have no time zone
+func inLocation(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+// parses a date written as text, trying Params.DateLayouts and then the ISO layouts
+func parseTextTime(s string, params Params) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	loc := paramsLocation(params)
+	for _, layouts := range [][]string{params.DateLayouts, isoDateLayouts} {
+		for _, layout := range layouts {
+			if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, errors.New("no layout matches " + s)
+}
+
+// parses a duration written as text: [-]h:mm[:ss[.fff]], where hours may exceed 24 as in excel's [h]:mm:ss,
+// or as written by time.Duration.String, eg. 1h30m
+func parseTextDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	invalid := errors.New("could not read " + s + " as a duration")
+	negative := strings.HasPrefix(s, "-")
+	parts := strings.Split(strings.TrimPrefix(s, "-"), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, invalid
+	}
+	units := []time.Duration{time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, part := range parts {
+		if i < len(parts)-1 && !isDigits(part) {
+			return 0, invalid
+		}
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 || (i > 0 && n >= 60) {
+			return 0, invalid
+		}
+		d += time.Duration(math.Round(n * float64(units[i])))
+	}
+	if negative {
+		d = -d
+	}
+	return d, nil
+}
+
+// converts an excel time, a fraction of a day, to a duration
+func serialToDuration(f float64) time.Duration {
+	return time.Duration(math.Round(f * float64(24*time.Hour)))
+}
@@ -0,0 +1,141 @@
+package excel_to_gorm
+
+import (
+	"testing"
+	"time"
+)
+
+type dateRow struct {
+	Date     time.Time      `xtg:"col:Date"`
+	UKDate   time.Time      `xtg:"col:UKDate,time:layout=02/01/2006;layout=02/01/2006 15:04"`
+	Duration time.Duration  `xtg:"col:Duration"`
+	Optional *time.Duration `xtg:"col:Optional"`
+}
+
+func dateSheets() map[string][][]interface{} {
+	return map[string][][]interface{}{"dates": {
+		{"Date", "UKDate", "Duration", "Optional"},
+		{44259.5, "04/03/2021", 0.0625, "1:30:15.5"},
+		{"2021-03-04 10:11", "04/03/2021 13:45", "26:00", nil},
+		{time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC), "2021-03-04", "90m", "-0:30"},
+	}}
+}
+
+func TestDates(t *testing.T) {
+	wb := mkBook(t, dateSheets())
+	out, err := WorksheetToSlice(wb.Sheet["dates"], &dateRow{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]dateRow)
+	hour := func(h int, m int, s int) time.Duration {
+		return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	}
+	want := []struct {
+		date     time.Time
+		ukDate   time.Time
+		duration time.Duration
+		optional time.Duration
+	}{
+		{time.Date(2021, 3, 4, 12, 0, 0, 0, time.UTC), time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC), hour(1, 30, 0), hour(1, 30, 15) + 500*time.Millisecond},
+		{time.Date(2021, 3, 4, 10, 11, 0, 0, time.UTC), time.Date(2021, 3, 4, 13, 45, 0, 0, time.UTC), hour(26, 0, 0), 0},
+		{time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC), time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC), hour(1, 30, 0), -hour(0, 30, 0)},
+	}
+	for i, w := range want {
+		r := got[i]
+		if !r.Date.Equal(w.date) || !r.UKDate.Equal(w.ukDate) || r.Duration != w.duration {
+			t.Errorf("row %v got %v, %v, %v", i+2, r.Date, r.UKDate, r.Duration)
+		}
+		if (r.Optional == nil) != (w.optional == 0) || (r.Optional != nil && *r.Optional != w.optional) {
+			t.Errorf("row %v got optional %v", i+2, r.Optional)
+		}
+	}
+}
+
+func TestDateLocation(t *testing.T) {
+	wb := mkBook(t, dateSheets())
+	est := time.FixedZone("EST", -5*60*60)
+	out, err := WorksheetToSlice(wb.Sheet["dates"], &dateRow{}, Params{Location: est})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the wall clock time is kept, in the given location
+	for _, r := range out.([]dateRow) {
+		if r.Date.Location() != est || r.UKDate.Location() != est {
+			t.Errorf("got %v and %v, expected times in EST", r.Date, r.UKDate)
+		}
+	}
+	if got := out.([]dateRow)[0].Date; !got.Equal(time.Date(2021, 3, 4, 12, 0, 0, 0, est)) {
+		t.Errorf("got %v", got)
+	}
+}
+
+type dateOnly struct {
+	Date time.Time `xtg:"col:Date"`
+}
+
+func TestDate1904(t *testing.T) {
+	wb := mkBook(t, map[string][][]interface{}{"dates": {{"Date"}, {42797.0}}})
+	wb.Date1904 = true
+	out, err := WorksheetToSlice(wb.Sheet["dates"], &dateOnly{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// serial 42797 is 2021-03-04 counting from 1904, rather than 2017-03-03 counting from 1900
+	if got := out.([]dateOnly)[0].Date; !got.Equal(time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestDateLayouts(t *testing.T) {
+	rows := [][]string{{"Date"}, {"20210304"}, {"04.03.2021"}, {"2021-03-04"}}
+	out, err := SourceToSlice(NewGridSource("dates", rows), &dateOnly{}, Params{DateLayouts: []string{"20060102", "02.01.2006"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range out.([]dateOnly) {
+		if !r.Date.Equal(time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("row %v got %v", i+2, r.Date)
+		}
+	}
+	// without a layout, 04/03/2021 is ambiguous
+	if out, err := SourceToSlice(NewGridSource("dates", [][]string{{"Date"}, {"04/03/2021"}}), &dateOnly{}, Params{}); err == nil {
+		t.Errorf("got %+v, expected an error", out)
+	}
+}
+
+// text cells holding a plain number are serial dates
+func TestSerialDateText(t *testing.T) {
+	wb := mkBook(t, map[string][][]interface{}{"dates": {{"Date"}, {"44000"}, {" 44259.5 "}}})
+	out, err := WorksheetToSlice(wb.Sheet["dates"], &dateOnly{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]dateOnly)
+	if !got[0].Date.Equal(time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC)) || !got[1].Date.Equal(time.Date(2021, 3, 4, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %+v", got)
+	}
+	wb = mkBook(t, map[string][][]interface{}{"dates": {{"Date"}, {"44000x"}}})
+	if out, err := WorksheetToSlice(wb.Sheet["dates"], &dateOnly{}, Params{}); err == nil {
+		t.Errorf("got %+v, expected an error", out)
+	}
+}
+
+func TestParseTextDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"1:30":      90 * time.Minute,
+		"100:00:01": 100*time.Hour + time.Second,
+		"-0:00:30":  -30 * time.Second,
+		"1h30m":     90 * time.Minute,
+	}
+	for in, want := range tests {
+		if got, err := parseTextDuration(in); err != nil || got != want {
+			t.Errorf("parseTextDuration(%q) got %v, %v, want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "1", "1:60", "a:00", "1:2:3:4", "1:-5"} {
+		if got, err := parseTextDuration(in); err == nil {
+			t.Errorf("parseTextDuration(%q) got %v, expected an error", in, got)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package excel_to_gorm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// RowView is the row of the sheet a record is read from, as passed to the functions of Params.Derive
+type RowView struct {
+	src      SheetSource
+	row      int
+	headings map[string]int // 1 based columns by heading
+	params   Params
+}
+
+// Row is the number of the row, starting at 1
+func (rv RowView) Row() int {
+	return rv.row + 1
+}
+
+// Headings lists the headings of the sheet in column order.  nil if it has no heading row
+func (rv RowView) Headings() []string {
+	if rv.headings == nil {
+		return nil
+	}
+	headings := make([]string, 0, len(rv.headings))
+	for heading := range rv.headings {
+		headings = append(headings, heading)
+	}
+	sort.Slice(headings, func(i, j int) bool { return rv.headings[headings[i]] < rv.headings[headings[j]] })
+	return headings
+}
+
+// Has says whether the sheet has a column with the heading
+func (rv RowView) Has(heading string) bool {
+	return rv.headings[heading] > 0
+}
+
+// Cell is the raw cell of the row in the column with the heading.  Empty if there is no such column
+func (rv RowView) Cell(heading string) CellValue {
+	col := rv.headings[heading]
+	if col == 0 {
+		return CellValue{}
+	}
+	return rv.src.Cell(rv.row, col-1)
+}
+
+// Text is the cell in the column with the heading as displayed, without surrounding spaces
+func (rv RowView) Text(heading string) string {
+	return strings.TrimSpace(rv.Cell(heading).Display())
+}
+
+// Float is the number in the column with the heading.  Numbers written as text are read with Params.Locale
+// and empty cells are 0
+func (rv RowView) Float(heading string) (float64, error) {
+	if !rv.Has(heading) {
+		return 0, errors.New("no column headed " + heading)
+	}
+	cv := rv.Cell(heading)
+	switch {
+	case cv.Kind == CellEmpty:
+		return 0, nil
+	case isTextNumber(cv, rv.params):
+		return textToNumber(cv, rv.params)
+	}
+	f, err := cv.Float()
+	if err != nil {
+		return 0, fmt.Errorf("could not read %v as a number in column %v", cv.Value, heading)
+	}
+	return f, nil
+}
+
+// evaluates an expr: against a row, where names are the cells of the row with those headings
+type rowFormulaEnv struct {
+	sheetFormulaEnv
+	row      int
+	headings map[string]int
+}
+
+func (env *rowFormulaEnv) absoluteRefsOnly() {}
+
+func (env *rowFormulaEnv) namedValue(name string) (formulaValue, bool, error) {
+	col := env.headings[name]
+	if col == 0 {
+		return formulaValue{}, false, nil
+	}
+	v, err := env.cellValue(env.row, col-1)
+	return v, true, err
+}
+
+// the names of an expr: are only checked against the headings, as the cells they refer to are empty
+type checkFormulaEnv struct {
+	headings map[string]int
+}
+
+func (env *checkFormulaEnv) cellValue(row, col int) (formulaValue, error) {
+	return formulaValue{}, nil
+}
+
+func (env *checkFormulaEnv) absoluteRefsOnly() {}
+
+func (env *checkFormulaEnv) namedValue(name string) (formulaValue, bool, error) {
+	return formulaValue{}, env.headings[name] > 0, nil
+}
+
+// checks an expr: parses and only names headings of the sheet
+func checkExpr(expr string, headings map[string]int) error {
+	_, err := evaluateFormula(expr, &checkFormulaEnv{headings: headings})
+	return err
+}
+
+// the value of a field computed by Params.Derive or its expr:
+func (sp *sheetPlan) derivedValue(src SheetSource, row int, fldIx int) (reflect.Value, error) {
+	fp := &sp.plan.Fields[fldIx]
+	// the result is reported against the first cell of the record
+	col := sp.firstCol
+	if col < 0 {
+		col = 0
+	}
+	if derive := sp.params.Derive[fp.Name]; derive != nil {
+		result, err := derive(RowView{src: src, row: row, headings: sp.headings, params: sp.params})
+		if err != nil {
+			return reflect.Zero(fp.Type), err
+		}
+		return sp.storeDerived(result, row, col, fldIx)
+	}
+
+	env := &rowFormulaEnv{sheetFormulaEnv: sheetFormulaEnv{src: src, visiting: make(map[[2]int]bool)}, row: row, headings: sp.headings}
+	result, err := evaluateFormula(fp.Tag.Expr, env)
+	if err != nil {
+		return reflect.Zero(fp.Type), fmt.Errorf("could not evaluate expr:%v. %w", fp.Tag.Expr, err)
+	}
+	cv := result.cell()
+	cv.Formula = fp.Tag.Expr
+	return sp.cellFieldValue(cv, row, col, fldIx)
+}
+
+// stores the result of a Params.Derive function in a field: as it is if it fits, converted between
+// numeric types, or converted like a cell if it is text
+func (sp *sheetPlan) storeDerived(result interface{}, row int, col int, fldIx int) (reflect.Value, error) {
+	fp := &sp.plan.Fields[fldIx]
+	if result == nil {
+		return reflect.Zero(fp.Type), nil
+	}
+	if text, ok := result.(string); ok {
+		return sp.cellFieldValue(csvCellValue(text), row, col, fldIx)
+	}
+	value := reflect.ValueOf(result)
+	elemType := fp.Type
+	if fp.Type.Kind() == reflect.Ptr && !value.Type().AssignableTo(fp.Type) {
+		elemType = fp.Type.Elem()
+	}
+	switch {
+	case value.Type().AssignableTo(elemType):
+	case isNumericKind(value.Kind()) && isNumericKind(elemType.Kind()):
+		value = value.Convert(elemType)
+	default:
+		return reflect.Zero(fp.Type), fmt.Errorf("Derive returned a %v, which cannot be stored in a %v", value.Type(), fp.Type)
+	}
+	if elemType != fp.Type {
+		ptr := reflect.New(elemType)
+		ptr.Elem().Set(value)
+		return ptr, nil
+	}
+	return value, nil
+}
+
+func isNumericKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package excel_to_gorm
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+type exprRow struct {
+	First string   `xtg:"col:First Name"`
+	Last  string   `xtg:"col:Last"`
+	Dia   float64  `xtg:"col:Diameter"`
+	Inch  float64  `xtg:"expr:Diameter*2.54"`
+	Full  string   `xtg:"expr:[First Name]&\" \"&Last"`
+	Total int      `xtg:"onerror:null,expr:SUM([Q1],[Q2],Q3,[Q4])"`
+	Ratio *float64 `xtg:"onerror:null,expr:Diameter/[Q1]"`
+	Big   bool     `xtg:"expr:IF(Diameter>10,TRUE,FALSE)"`
+}
+
+var exprRows = [][]string{
+	{"First Name", "Last", "Diameter", "Q1", "Q2", "Q3", "Q4"},
+	{"Ann", "Lee", "9.8", "1", "2", "3", "4"},
+	{"Bo", "Ng", "11", "0", "", "#N/A", "1"},
+}
+
+func TestExpr(t *testing.T) {
+	report := &Report{}
+	out, err := SourceToSlice(NewGridSource("s", exprRows), &exprRow{}, Params{Report: report})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]exprRow)
+	// Q3 is a heading, so is the cell of the row rather than the cell Q3
+	if r := got[0]; math.Abs(r.Inch-24.892) > 1e-9 || r.Full != "Ann Lee" || r.Total != 10 || r.Ratio == nil || *r.Ratio != 9.8 || r.Big {
+		t.Errorf("got %+v", r)
+	}
+	if r := got[1]; r.Total != 0 || r.Ratio != nil || !r.Big {
+		t.Errorf("got %+v", r)
+	}
+	if issues := report.Issues(); len(issues) != 2 || issues[0].Code != IssueCellError || issues[0].Field != "Total" {
+		t.Errorf("got issues %+v", issues)
+	}
+}
+
+func TestExprCellReferences(t *testing.T) {
+	// the rate is in E1, beside the headings of the table
+	rows := [][]string{{"Name", "Price", "", "Rate", "2"}, {"Gala", "3"}}
+	type priced struct {
+		Name  string  `xtg:"col:Name"`
+		Price float64 `xtg:"expr:Price*$E$1"`
+	}
+	out, err := SourceToSlice(NewGridSource("s", rows), &priced{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.([]priced); len(got) != 1 || got[0].Price != 6 {
+		t.Errorf("got %+v", got)
+	}
+
+	// names which are not headings are not read as cells, even if they look like one
+	for _, expr := range []string{"Price*B1", "Price*$B1", "SUM(A1:A2)", "SUM($A$1:A2)", "Nope*2"} {
+		err := checkExpr(expr, map[string]int{"Name": 1, "Price": 2})
+		if err == nil {
+			t.Errorf("checkExpr(%q) expected an error", expr)
+		}
+	}
+	if err := checkExpr("SUM($A$1:$A$2)+Price", map[string]int{"Price": 2}); err != nil {
+		t.Errorf("checkExpr got %v", err)
+	}
+}
+
+func TestInvalidExpr(t *testing.T) {
+	type cellLike struct {
+		X float64 `xtg:"expr:AB12*2"`
+	}
+	_, err := SourceToSlice(NewGridSource("s", exprRows), &cellLike{}, Params{})
+	if err == nil || !strings.Contains(err.Error(), "AB12") {
+		t.Errorf("got %v, expected an error naming AB12", err)
+	}
+	type unknown struct {
+		X float64 `xtg:"expr:[Nope]*2"`
+	}
+	if _, err := SourceToSlice(NewGridSource("s", exprRows), &unknown{}, Params{}); err == nil {
+		t.Error("expected an error for an unknown [name]")
+	}
+	type unfinished struct {
+		X float64 `xtg:"expr:(1+"`
+	}
+	if _, err := SourceToSlice(NewGridSource("s", exprRows), &unfinished{}, Params{}); err == nil {
+		t.Error("expected an error for an unfinished expr:")
+	}
+}
+
+type derivedRow struct {
+	Last    string   `xtg:"col:Last"`
+	Label   string   // computed by Params.Derive
+	Scaled  *int     // computed by Params.Derive
+	Percent *float64 // computed by Params.Derive, as text
+}
+
+func TestDerive(t *testing.T) {
+	derive := map[string]func(RowView) (interface{}, error){
+		"Label": func(rv RowView) (interface{}, error) {
+			return rv.Text("Last") + "/" + strings.Join(rv.Headings()[:2], ","), nil
+		},
+		"Scaled": func(rv RowView) (interface{}, error) {
+			f, err := rv.Float("Q4")
+			return f * 10, err
+		},
+		"Percent": func(rv RowView) (interface{}, error) {
+			if !rv.Has("Q1") || rv.Row() != 2 {
+				return nil, nil
+			}
+			return rv.Cell("Q1").Value + "%", nil
+		},
+	}
+	out, err := SourceToSlice(NewGridSource("s", exprRows), &derivedRow{}, Params{Derive: derive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]derivedRow)
+	if r := got[0]; r.Label != "Lee/First Name,Last" || r.Scaled == nil || *r.Scaled != 40 || r.Percent == nil || *r.Percent != 0.01 {
+		t.Errorf("got %+v", r)
+	}
+	if r := got[1]; r.Scaled == nil || *r.Scaled != 10 || r.Percent != nil {
+		t.Errorf("got %+v", r)
+	}
+
+	derive["Label"] = func(rv RowView) (interface{}, error) { return nil, errors.New("no label") }
+	if _, err := SourceToSlice(NewGridSource("s", exprRows), &derivedRow{}, Params{Derive: derive}); err == nil || !strings.Contains(err.Error(), "no label") {
+		t.Errorf("got %v, expected the error of Derive", err)
+	}
+	derive["Label"] = func(rv RowView) (interface{}, error) { return []int{1}, nil }
+	if _, err := SourceToSlice(NewGridSource("s", exprRows), &derivedRow{}, Params{Derive: derive}); err == nil {
+		t.Error("expected an error for a result which does not fit the field")
+	}
+}
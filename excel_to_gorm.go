@@ -1,15 +1,21 @@
 package excel_to_gorm
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/c4rnot/csv_to_gorm"
 	"github.com/tealeg/xlsx/v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
 	//"github.com/c4rnot/xlsx/v3"
 )
 
@@ -23,32 +29,204 @@ import (
 *
 * mapConst : parameter is the key to the Params constMap.  Value becomes the constant associated with the key
 * col: The column name associated with this field
+* colref: The column letter(s) of this field, eg. colref:C.  Does not need a heading row
+* colidx: The column number of this field, starting at 1, eg. colidx:3.  Does not need a heading row
 * intcols:colname  xtg will parse all columns whose column names  can parse as an integer.  A separate database record is created for each one
 * intcols:value  This field is the value associated with the column headed by an integer.
 * melt:colname  takes all colums not declared with col: and creates a separate record for each
 * melt:value  takes value associated with colums not declared with col:
 * ignore:  takes a ; separated list of strings.  These columns are ignored for melt
+* sheetname  takes no parameter.  The field is filled with the name of the sheet the record was read from
+* meta:  fills the field with where the record came from, rather than cell data:
+*     meta:row  the row number of the record, starting at 1
+*     meta:sheet  the name of the sheet (same as sheetname)
+*     meta:file  Params.FileName, which ExcelFileToSlice sets to the file name
+*     meta:col  the heading of the melt column (or if no melt, the int column) the record was created for
+*     meta:colref  the column letter(s) of the melt or int column
+*     meta:cell  the address, eg. C12, of the melt or int column value.  For records without melt or intcols, the first cell read
+*     meta:run  the ID of the ImportRun, when imported by ImportSheet.  Lets a bad upload be removed wholesale
+*     meta:formula  the formula of the same cell as meta:cell, without the leading =.  Empty if it holds a plain value
+* onerror:  what to do when the cell of the field holds an error such as #N/A or #DIV/0!.  Overrides Params.OnCellError:
+*     onerror:value  convert the error like any other text (the default)
+*     onerror:null  leave the field empty, or nil if it is a pointer
+*     onerror:zero  set the field to zero, even if it is a pointer
+*     onerror:nan  set float fields to NaN.  Other fields are left empty
+*     onerror:skip  leave the record out
+*     onerror:fail  stop the conversion with an error
+* num:  how numbers written as text are read into int and float fields, as ; separated options:
+*     num:locale=<locale>  the separators of the locale, eg. num:locale=de reads 1.234,56.  Overrides Params.Locale
+* time:  how dates written as text are read into time.Time fields, as ; separated options:
+*     time:layout=<layout>  a layout of time.Parse, eg. time:layout=02/01/2006.  Repeat to try several.  Overrides Params.DateLayouts
+*     Text matching no layout but holding a plain number is read as a serial date, eg. "44000" is 2020-06-18
+* bool:  the words read into bool fields, as , separated options of ; separated words.  Overrides Params.TrueValues and friends:
+*     bool:true=Oui;Ja,false=Non;Nein,blank=error  blank is false, true or error
+* lookup:  maps the text of the cell through a table of Params.Lookups before it is converted, eg. "Cooking apple" to 1.
+*     Also maps melt:colname and intcols:colname headings.  Options follow the name of the table, separated by ;
+*     lookup:<name>;ci;default=<value>  ci ignores case.  Text missing from the table becomes the default, if any,
+*     otherwise Params.OnUnmapped applies
+* default:  the value of the field when its cell is empty, eg. default:Unknown.  Converted like the text of a cell,
+*     so it must suit the field, except for fk: fields where it is the text looked up.  Applies to col:, intcols:value,
+*     melt:value and expr: fields.  Overrides gorm:"default:<value>"
+* fallback:  the heading of a column read when the cell of the field is empty, eg. col:Name,fallback:Alt Name.
+*     Separate several with ; to try them in turn.  The default: applies if they are all empty
+* transform:  cleans up the text of the cell before it is looked up and converted, as | separated steps, eg. transform:trim|upper.
+*     Also applies to melt:colname and intcols:colname headings.  Steps taking arguments separate them with ;
+*     trim, upper, lower  remove surrounding spaces and change case
+*     replace(<old>;<new>)  replaces all of old.  replace(<old>) removes it
+*     ungroup  removes thousands separators, ie. commas and spaces.  ungroup(.;') removes the separators given
+*     scale(<n>)  multiplies the number by n, eg. scale(100) turns fractions into percentages
+*     round(<n>)  rounds the number to n decimal places.  round rounds to a whole number
+*     RegisterTransform adds more.  Arguments cannot hold , or |
+* fk:  stores the key of the parent row whose field matches the text of the cell, eg. xtg:"col:Country,fk:Country.Name->ID"
+*     on a CountryID field.  Parents are looked up through Params.DB in batches once the sheet is read.  ->ID may be left out.
+*     Text is matched as the database compares it, so ignoring case if the collation of the column does.
+*     fk:Country.Name->ID;create creates missing parents, otherwise Params.OnUnmapped applies.  UndoImport removes
+*     the parents created by ImportSheet
+* children  on a slice of structs, eg. Trees []Tree, fills it with records of the child model read from the same rows.
+*     Rows with the same key fields make one record of the model, holding the children of all of them, so db.Create
+*     inserts the whole graph.  Rows with empty key cells belong to the record above
+* children:<Field>=<ChildField>  the children are read from another sheet, by Workbook.ToSliceWithChildren or
+*     AttachChildren, and belong to the record whose Field equals their ChildField
+* key  marks the fields identifying a record with children.  If none are marked, every field read from a column is a key
+* expr:  computes the field from other cells of the row with a formula, eg. expr:Diameter*2.54 or expr:[First Name]&" "&[Last Name].
+*     Names and [bracketed names] are the values of the columns with those headings; otherwise the formulas of
+*     Params.EvaluateFormulas apply, eg. expr:SUM([Q1],[Q2],[Q3],[Q4]).  Other cells are absolute references, eg. $B$1,
+*     so names which are not headings are an error.  As it may hold commas, expr: must come last in the tag.
+*     The result is converted like a cell, so onerror: and friends apply.  Params.Derive computes fields in Go instead
+*
+* gorm tags are also honoured, so the model only needs to be described once:
+* gorm:"column:<name>"  if the field has no col: instruction, the DB column name is used as the column heading
+* gorm:"-"  the field is never filled from the sheet
+* gorm:"default:<value>"  the value used when the cell is empty, unless it cannot be converted, which leaves it to the database
+* gorm:"embedded" and embedded structs such as gorm.Model  the fields of the embedded struct are mapped as if declared on the model
  */
 
 type Tag struct {
-	HasTag         bool
-	HasColanme     bool
-	Colname        string
-	IsIntColsHead  bool
-	IsIntColsValue bool
-	IsMapConst     bool
-	ConstMapKey    string
-	IsMeltHead     bool
-	IsMeltValue    bool
-	Ignore         []string
+	HasTag           bool
+	HasColanme       bool
+	Colname          string
+	HasColIdx        bool
+	ColIdx           int // 1 based column number from colref: or colidx:
+	IsIntColsHead    bool
+	IsIntColsValue   bool
+	IsMapConst       bool
+	ConstMapKey      string
+	IsMeltHead       bool
+	IsMeltValue      bool
+	Ignore           []string
+	IsSheetName      bool
+	IsMeta           bool
+	Meta             string   // row, sheet, file, col, colref, cell, run or formula
+	SkipField        bool     // gorm:"-"
+	HasDBColumn      bool     // gorm:"column:<name>"
+	DBColumn         string   // used as the column heading if there is no col: instruction
+	HasDefault       bool     // default:<value> or gorm:"default:<value>"
+	Default          string   // parsed into the field if the cell is empty
+	IsGormDefault    bool     // the default is from the gorm tag, so is left to the database if it does not convert
+	Fallbacks        []string // fallback:<heading>;<heading>
+	HasOnCellError   bool
+	OnCellError      ErrorPolicy // onerror:<policy>
+	NumLocale        string      // num:locale=<locale>
+	TimeLayouts      []string    // time:layout=<layout>
+	TrueValues       []string    // bool:true=<words>
+	FalseValues      []string    // bool:false=<words>
+	HasBlankBool     bool
+	BlankBool        BlankBool       // bool:blank=<false|true|error>
+	Transforms       []TransformStep // transform:<step>|<step>
+	Lookup           string          // lookup:<name>
+	LookupCI         bool            // lookup:<name>;ci
+	HasLookupDefault bool
+	LookupDefault    string // lookup:<name>;default=<value>
+	ForeignKey       *ForeignKey
+	IsChildren       bool   // children or children:<Field>=<ChildField>
+	JoinField        string // <Field> of children:<Field>=<ChildField>
+	JoinChildField   string // <ChildField> of children:<Field>=<ChildField>
+	IsKey            bool   // key
+	Expr             string // expr:<expression>
+}
+
+// BlankBool says how an empty cell is read into a bool field
+type BlankBool int
+
+const (
+	BlankIsFalse BlankBool = iota
+	BlankIsTrue
+	BlankIsError
+)
+
+// the words read as true and false when Params.TrueValues and FalseValues are not set, ignoring case.
+// Bool and number cells are true if 1 and false if 0
+var (
+	DefaultTrueValues  = []string{"true", "t", "yes", "y", "on", "1"}
+	DefaultFalseValues = []string{"false", "f", "no", "n", "off", "0"}
+)
+
+// ErrorPolicy says how a cell holding an error value, such as #N/A or #DIV/0!, is converted
+type ErrorPolicy int
+
+const (
+	OnErrorValue      ErrorPolicy = iota // convert the error code like any other text: string fields get eg. "#N/A", floats NaN and other types fail
+	OnErrorNull                          // leave the field as its zero value, or nil for pointer fields
+	OnErrorZero                          // set the field to its zero value, allocating pointer fields
+	OnErrorNaN                           // set float fields to NaN and leave others as OnErrorNull
+	OnErrorSkipRecord                    // leave out the record
+	OnErrorFail                          // stop the conversion with an error
+)
+
+// the names of ErrorPolicies in onerror: tags
+var errorPolicyNames = map[string]ErrorPolicy{
+	"value": OnErrorValue,
+	"null":  OnErrorNull,
+	"zero":  OnErrorZero,
+	"nan":   OnErrorNaN,
+	"skip":  OnErrorSkipRecord,
+	"fail":  OnErrorFail,
 }
 
 type Params struct {
 	ColMap          map[string]int    // maps fieldnames to column numbers(starting at 1).  Overrides tagnames if mapping present
+	ColRefMap       map[string]string // maps fieldnames to column letters (eg. "C").  Overrides ColMap and tagnames if mapping present
 	ConstMap        map[string]string // maps from tagname mapConst:Mapfrom to a string constant to be parsed into the field
 	FirstRowHasData bool
 	ErrorOnNaN      bool
 	//ErrorOnInf bool
+	FileName     string // recorded by meta:file tags. ExcelFileToSlice sets it if empty
+	ImportRunID  uint   // recorded by meta:run tags. Set by ImportSheet
+	Concurrency  int    // maximum number of sheets WorkbookToSlices converts at once.  0 means one per CPU
+	CSVDelimiter rune   // separates the fields read by CSVToSlice, eg. '\t' for TSV.  0 guesses from the first line
+	CSVQuote     rune   // quotes fields read by CSVToSlice.  0 means ", and a negative value turns quoting off
+	CSVEncoding  string // character encoding read by CSVToSlice, eg. "windows-1252" or "utf-16le".  "" means UTF-8
+	FillMerged   bool   // cells covered by a merged cell take the value of the merged cell, rather than being empty
+	// formula cells without a saved result, as written by scripts, are evaluated if the formula only uses
+	// arithmetic, comparisons, &, cells of the same sheet, SUM, MIN, MAX, AVERAGE, COUNT, ROUND, ABS and IF.
+	// Otherwise they are read as empty
+	EvaluateFormulas bool
+	Report           *Report // if set, collects issues such as formulas which could not be evaluated
+	// what to do with cells holding errors such as #N/A, unless the field has an onerror: tag.
+	// Error cells are reported to Report whatever the policy
+	OnCellError ErrorPolicy
+	// the locale of numbers written as text, eg. "de" for 1.234,56 or "fr" for 1 234,56.  "" is English.
+	// Currency symbols and % are removed, and (500) is negative
+	Locale string
+	// layouts of time.Parse tried on dates written as text, eg. "02/01/2006", before ISO dates such as
+	// 2021-03-04 which are always read
+	DateLayouts []string
+	// the time zone of dates and times which have none, as excel's never do.  nil means UTC
+	Location *time.Location
+	// the words, ignoring case, read as true and false by bool fields.  Anything else is an error.
+	// nil means DefaultTrueValues and DefaultFalseValues
+	TrueValues  []string
+	FalseValues []string
+	BlankBool   BlankBool // how empty cells are read by bool fields.  Pointer fields are always nil
+	// tables named by lookup: tags, mapping the text of cells to the values converted into fields,
+	// eg. {"countries": {"UK": "United Kingdom"}}
+	Lookups    map[string]map[string]string
+	OnUnmapped UnmappedPolicy // what happens to text missing from a lookup, or a parent table
+	DB         *gorm.DB       // looks up the parents of fk: fields.  ImportSheet and ImportBytes use their transaction instead
+	// computes fields, by field name, from the raw cells of the row, eg. to join names or sum quarters.
+	// Takes precedence over the tags of the field.  The result is stored if it fits the field, text is converted
+	// like a cell, and a nil result leaves the field empty
+	Derive map[string]func(row RowView) (interface{}, error)
 }
 
 func parseTag(field reflect.StructField) (Tag, error) {
@@ -61,10 +239,26 @@ func parseTag(field reflect.StructField) (Tag, error) {
 	} else {
 		tag.HasTag = true
 	}
+	// an expression takes the rest of the tag, as it may hold commas
+	if i := strings.Index(value, "expr:"); i == 0 || i > 0 && value[i-1] == ',' {
+		tag.Expr = strings.TrimSpace(value[i+len("expr:"):])
+		if tag.Expr == "" {
+			return tag, errors.New("expression missing for field: " + field.Name + ". should be in the form expr:<expression>")
+		}
+		value = strings.TrimSuffix(value[:i], ",")
+	}
 	subTags := strings.Split(value, ",")
+	// options of an instruction may themselves be separated by commas, eg. bool:true=Ja,false=Nein
+	for i := len(subTags) - 1; i > 0; i-- {
+		if !strings.Contains(subTags[i], ":") && strings.Contains(subTags[i], "=") {
+			subTags[i-1] += "," + subTags[i]
+			subTags = append(subTags[:i], subTags[i+1:]...)
+		}
+	}
 
 	for _, subTag := range subTags {
-		subTagElements := strings.Split(subTag, ":")
+		// the parameter may contain colons, eg. time:layout=15:04
+		subTagElements := strings.SplitN(subTag, ":", 2)
 		switch subTagElements[0] {
 		case "col":
 			tag.HasColanme = true
@@ -72,6 +266,26 @@ func parseTag(field reflect.StructField) (Tag, error) {
 				return tag, errors.New("column name missing for field: " + field.Name + ". should be in the form col:<colname>")
 			}
 			tag.Colname = subTagElements[1]
+		case "colref":
+			if len(subTagElements) < 2 {
+				return tag, errors.New("column letter missing for field: " + field.Name + ". should be in the form colref:<letters>")
+			}
+			colNo, err := colLettersToNumber(subTagElements[1])
+			if err != nil {
+				return tag, fmt.Errorf("invalid colref for field: %v. %w", field.Name, err)
+			}
+			tag.HasColIdx = true
+			tag.ColIdx = colNo
+		case "colidx":
+			if len(subTagElements) < 2 {
+				return tag, errors.New("column number missing for field: " + field.Name + ". should be in the form colidx:<number>")
+			}
+			colNo, err := strconv.Atoi(strings.TrimSpace(subTagElements[1]))
+			if err != nil || colNo < 1 {
+				return tag, errors.New("invalid colidx for field: " + field.Name + ". column numbers start at 1")
+			}
+			tag.HasColIdx = true
+			tag.ColIdx = colNo
 		case "mapConst":
 			tag.IsMapConst = true
 			if len(subTagElements) < 2 {
@@ -106,21 +320,217 @@ func parseTag(field reflect.StructField) (Tag, error) {
 			}
 			ignoreStrings := strings.Split(subTagElements[1], ";")
 			tag.Ignore = ignoreStrings
+		case "sheetname":
+			tag.IsSheetName = true
+			tag.IsMeta = true
+			tag.Meta = "sheet"
+		case "meta":
+			if len(subTagElements) < 2 {
+				return tag, errors.New("metadata missing for field: " + field.Name + ". should be in the form meta:<row|sheet|file|col|colref|cell|run|formula>")
+			}
+			meta := strings.ToLower(strings.TrimSpace(subTagElements[1]))
+			switch meta {
+			case "row", "sheet", "file", "col", "colref", "cell", "run", "formula":
+			default:
+				return tag, errors.New("unknown metadata " + meta + " for field: " + field.Name + ". should be one of row, sheet, file, col, colref, cell, run or formula")
+			}
+			tag.IsMeta = true
+			tag.Meta = meta
+		case "onerror":
+			if len(subTagElements) < 2 {
+				return tag, errors.New("policy missing for field: " + field.Name + ". should be in the form onerror:<value|null|zero|nan|skip|fail>")
+			}
+			policy, ok := errorPolicyNames[strings.ToLower(strings.TrimSpace(subTagElements[1]))]
+			if !ok {
+				return tag, errors.New("unknown onerror policy " + subTagElements[1] + " for field: " + field.Name + ". should be one of value, null, zero, nan, skip or fail")
+			}
+			tag.HasOnCellError = true
+			tag.OnCellError = policy
+		case "num":
+			if len(subTagElements) < 2 {
+				return tag, errors.New("options missing for field: " + field.Name + ". should be in the form num:locale=<locale>")
+			}
+			for _, option := range strings.Split(subTagElements[1], ";") {
+				keyValue := strings.SplitN(option, "=", 2)
+				switch strings.TrimSpace(keyValue[0]) {
+				case "locale":
+					if len(keyValue) < 2 {
+						return tag, errors.New("locale missing for field: " + field.Name + ". should be in the form num:locale=<locale>")
+					}
+					if _, err := lookupNumberLocale(keyValue[1]); err != nil {
+						return tag, fmt.Errorf("invalid num: tag for field: %v. %w", field.Name, err)
+					}
+					tag.NumLocale = strings.TrimSpace(keyValue[1])
+				default:
+					return tag, errors.New("unknown num: option " + option + " for field: " + field.Name + ". should be in the form num:locale=<locale>")
+				}
+			}
+		case "time":
+			if len(subTagElements) < 2 {
+				return tag, errors.New("options missing for field: " + field.Name + ". should be in the form time:layout=<layout>")
+			}
+			for _, option := range strings.Split(subTagElements[1], ";") {
+				keyValue := strings.SplitN(option, "=", 2)
+				if strings.TrimSpace(keyValue[0]) != "layout" || len(keyValue) < 2 || keyValue[1] == "" {
+					return tag, errors.New("unknown time: option " + option + " for field: " + field.Name + ". should be in the form time:layout=<layout>")
+				}
+				tag.TimeLayouts = append(tag.TimeLayouts, keyValue[1])
+			}
+		case "bool":
+			if len(subTagElements) < 2 {
+				return tag, errors.New("options missing for field: " + field.Name + ". should be in the form bool:true=<words>,false=<words>,blank=<false|true|error>")
+			}
+			for _, option := range strings.Split(subTagElements[1], ",") {
+				keyValue := strings.SplitN(option, "=", 2)
+				if len(keyValue) < 2 {
+					return tag, errors.New("invalid bool: option " + option + " for field: " + field.Name + ". should be in the form bool:true=<words>,false=<words>,blank=<false|true|error>")
+				}
+				switch strings.TrimSpace(keyValue[0]) {
+				case "true":
+					tag.TrueValues = strings.Split(keyValue[1], ";")
+				case "false":
+					tag.FalseValues = strings.Split(keyValue[1], ";")
+				case "blank":
+					blank, ok := map[string]BlankBool{"false": BlankIsFalse, "true": BlankIsTrue, "error": BlankIsError}[strings.TrimSpace(keyValue[1])]
+					if !ok {
+						return tag, errors.New("invalid bool:blank= for field: " + field.Name + ". should be false, true or error")
+					}
+					tag.HasBlankBool = true
+					tag.BlankBool = blank
+				default:
+					return tag, errors.New("unknown bool: option " + option + " for field: " + field.Name + ". should be in the form bool:true=<words>,false=<words>,blank=<false|true|error>")
+				}
+			}
+		case "transform":
+			if len(subTagElements) < 2 {
+				return tag, errors.New("transform missing for field: " + field.Name + ". should be in the form transform:<step>|<step>")
+			}
+			steps, err := parseTransforms(subTagElements[1])
+			if err != nil {
+				return tag, fmt.Errorf("invalid transform: for field: %v. %w", field.Name, err)
+			}
+			tag.Transforms = steps
+		case "lookup":
+			if len(subTagElements) < 2 || strings.TrimSpace(strings.Split(subTagElements[1], ";")[0]) == "" {
+				return tag, errors.New("lookup name missing for field: " + field.Name + ". should be in the form lookup:<name>;ci;default=<value>")
+			}
+			options := strings.Split(subTagElements[1], ";")
+			tag.Lookup = strings.TrimSpace(options[0])
+			for _, option := range options[1:] {
+				keyValue := strings.SplitN(option, "=", 2)
+				switch {
+				case strings.TrimSpace(option) == "ci":
+					tag.LookupCI = true
+				case strings.TrimSpace(keyValue[0]) == "default" && len(keyValue) == 2:
+					tag.HasLookupDefault = true
+					tag.LookupDefault = keyValue[1]
+				default:
+					return tag, errors.New("unknown lookup: option " + option + " for field: " + field.Name + ". should be in the form lookup:<name>;ci;default=<value>")
+				}
+			}
+		case "children":
+			tag.IsChildren = true
+			if len(subTagElements) < 2 {
+				continue
+			}
+			join := strings.SplitN(subTagElements[1], "=", 2)
+			if len(join) < 2 || strings.TrimSpace(join[0]) == "" || strings.TrimSpace(join[1]) == "" {
+				return tag, errors.New("invalid children: tag for field: " + field.Name + ". should be children or children:<Field>=<ChildField>")
+			}
+			tag.JoinField = strings.TrimSpace(join[0])
+			tag.JoinChildField = strings.TrimSpace(join[1])
+		case "key":
+			tag.IsKey = true
+		case "default":
+			if len(subTagElements) < 2 || subTagElements[1] == "" {
+				return tag, errors.New("default value missing for field: " + field.Name + ". should be in the form default:<value>")
+			}
+			tag.HasDefault = true
+			tag.Default = subTagElements[1]
+		case "fallback":
+			if len(subTagElements) < 2 || strings.TrimSpace(subTagElements[1]) == "" {
+				return tag, errors.New("fallback heading missing for field: " + field.Name + ". should be in the form fallback:<heading>;<heading>")
+			}
+			tag.Fallbacks = strings.Split(subTagElements[1], ";")
+		case "fk":
+			if len(subTagElements) < 2 {
+				return tag, errors.New("parent missing for field: " + field.Name + ". should be in the form fk:<Model>.<Field>-><KeyField>;create")
+			}
+			fk, err := parseForeignKey(subTagElements[1])
+			if err != nil {
+				return tag, fmt.Errorf("invalid fk: tag for field: %v. %w", field.Name, err)
+			}
+			tag.ForeignKey = fk
 		}
 	}
 	return tag, nil
 }
 
+// applies the gorm tags of a field to an xtg Tag
+func parseGormTag(field *schema.Field, tag *Tag) {
+	if val, ok := field.TagSettings["-"]; ok {
+		val = strings.ToLower(strings.TrimSpace(val))
+		if val == "-" || val == "all" {
+			tag.SkipField = true
+		}
+	}
+	if dbName, ok := field.TagSettings["COLUMN"]; ok && dbName != "" {
+		tag.HasDBColumn = true
+		tag.DBColumn = field.DBName // includes any embeddedPrefix
+	}
+	// defaults which are database functions or null cannot be parsed into the field
+	dflt := strings.TrimSpace(field.DefaultValue)
+	if !tag.HasDefault && field.HasDefaultValue && dflt != "" && strings.ToLower(dflt) != "null" && !strings.Contains(dflt, "(") {
+		tag.HasDefault = true
+		tag.Default = strings.Trim(dflt, "'\"")
+		tag.IsGormDefault = true
+	}
+}
+
+// converts excel column letters (eg. "C" or "AB") to a column number starting at 1
+func colLettersToNumber(letters string) (int, error) {
+	letters = strings.ToUpper(strings.TrimSpace(letters))
+	if letters == "" {
+		return 0, errors.New("empty column reference")
+	}
+	colNo := 0
+	for _, l := range letters {
+		if l < 'A' || l > 'Z' {
+			return 0, errors.New("column reference " + letters + " should only contain letters")
+		}
+		colNo = colNo*26 + int(l-'A'+1)
+	}
+	return colNo, nil
+}
+
 func ExcelFileToSlice(fileName string, sheetName string, model interface{}, params Params) (interface{}, error) {
 	modelTyp := reflect.ValueOf(model).Elem().Type()
 	objSlice := reflect.Zero(reflect.SliceOf(modelTyp))
 
-	wb, err := xlsx.OpenFile(fileName)
+	w, err := Open(fileName)
 	if err != nil {
-		return objSlice.Interface(), errors.New("could not open file: " + fileName)
+		return objSlice.Interface(), err
 	}
-	result, err := WorkbookToSlice(wb, sheetName, model, params)
-	return result, err
+	defer w.Close()
+	return w.ToSlice(sheetName, model, params)
+}
+
+// reads a sheet of a workbook from r, eg. an uploaded multipart.File, without writing it to disk first
+func ReaderToSlice(r io.ReaderAt, size int64, sheetName string, model interface{}, params Params) (interface{}, error) {
+	modelTyp := reflect.ValueOf(model).Elem().Type()
+	objSlice := reflect.Zero(reflect.SliceOf(modelTyp))
+
+	w, err := OpenReader(r, size, params.FileName)
+	if err != nil {
+		return objSlice.Interface(), err
+	}
+	defer w.Close()
+	return w.ToSlice(sheetName, model, params)
+}
+
+// reads a sheet of a workbook held in memory
+func BytesToSlice(data []byte, sheetName string, model interface{}, params Params) (interface{}, error) {
+	return ReaderToSlice(bytes.NewReader(data), int64(len(data)), sheetName, model, params)
 }
 
 // allows calling function to keep file open
@@ -134,8 +544,7 @@ func WorkbookToSlice(wb *xlsx.File, sheetName string, model interface{}, params
 	if !ok {
 		return objSlice.Interface(), errors.New("could not find sheet:  " + sheetName)
 	}
-	defer sh.Close()
-	result, err := WorksheetToSlice(sh, model, params)
+	result, err := convertSheet(sh, model, params, true)
 	return result, err
 }
 
@@ -144,282 +553,107 @@ func WorkbookToSlice(wb *xlsx.File, sheetName string, model interface{}, params
 // calling function needs to import "github.com/tealeg/xlsx/v3" and pass a pointer to an xlsx.Sheet
 // eg.: sh, ok := wb.Sheet[sheetName]
 func WorksheetToSlice(sh *xlsx.Sheet, model interface{}, params Params) (interface{}, error) {
+	return SourceToSlice(newXlsxSource(sh), model, params)
+}
 
-	// map of column headings to 1 based column numbers (for consistency with csv_to_gorm)
-	var lclColMap map[string]int
-	var definedCols []string
-	var hasIntCols bool
-	var intColHdgs []string
-	var hasMelt bool
-	var meltColHdgs []string
-	var ignore []string
-	var csvParams csv_to_gorm.Params
+// converts a sheet of any format into a slice of the model, eg. from NewGridSource to test a model
+func SourceToSlice(src SheetSource, model interface{}, params Params) (interface{}, error) {
+	records, _, err := sourceToSlice(src, model, params)
+	return records, err
+}
 
+// as SourceToSlice, also returning the plan bound to the sheet, which is nil if the sheet is empty
+func sourceToSlice(src SheetSource, model interface{}, params Params) (interface{}, *sheetPlan, error) {
+	var csvParams csv_to_gorm.Params
 	CopyIdenticalFields(params, &csvParams)
 
 	// determine what type of model we are trying to fill records of
 	modelTyp := reflect.ValueOf(model).Elem().Type()
-	modelNumFlds := modelTyp.NumField()
 
 	// make an empty slice to hold the records to be uploaded to the db.
 	objSlice := reflect.Zero(reflect.SliceOf(modelTyp))
 
-	err := sh.ForEachRow(func(r *xlsx.Row) error {
+	plan, err := planFor(modelTyp)
+	if err != nil {
+		return objSlice.Interface(), nil, err
+	}
+	if params.FillMerged {
+		src = fillMerged(src)
+	}
+	maxRow := 0
+	if counter, ok := src.(rowCounter); ok {
+		maxRow = counter.MaxRow()
+	}
 
-		// Get headings from first row if necessary
-		if r.GetCoordinate() == 0 {
-			if !params.FirstRowHasData {
-				lclColMap = mapHeadingToCol(r)
-				intColHdgs = getIntCols(r)
-
-				// check if there is an intcol tag, as a db entry has to be made for each int col
-				for fldIx := 0; fldIx < modelNumFlds; fldIx++ {
-					tag, err := parseTag(modelTyp.Field(fldIx))
-					if err != nil {
-						return fmt.Errorf("could not parse tag for sheetname:  "+sh.Name+". ", err)
-					}
-					if tag.IsIntColsHead || tag.IsIntColsValue {
-						hasIntCols = true
-					}
-					if tag.IsMeltHead || tag.IsMeltValue {
-						hasMelt = true
-					}
-					if len(tag.Ignore) > 0 {
-						ignore = append(ignore, tag.Ignore...)
-					}
-					if tag.HasColanme {
-						definedCols = append(definedCols, tag.Colname)
-					}
-				}
-				if !hasMelt {
-					meltColHdgs = []string{}
-				} else {
-					meltColHdgs = getMeltCols(r, params.ColMap, definedCols, ignore, hasIntCols, intColHdgs)
-				}
-				return nil
-			}
-			// any other special first row code for case where where data is in first row here
+	// without a heading row, the columns of the sheet are known before reading it
+	var sp *sheetPlan
+	if params.FirstRowHasData {
+		sp, err = plan.bind(src, nil, params, csvParams)
+		if err != nil {
+			return objSlice.Interface(), sp, err
 		}
+		objSlice = reflect.MakeSlice(objSlice.Type(), 0, sp.recordsPerRow()*maxRow)
+	}
 
-		// create the new item to add to the database
-		dbRecordPtr := reflect.New(modelTyp)
-
-		if hasIntCols && !hasMelt {
-			for _, intColHdg := range intColHdgs {
-				// create the new item to add to the database
-				dbRecordPtr = reflect.New(modelTyp)
-
-				// for each field in the model
-				for fldIx := 0; fldIx < modelNumFlds; fldIx++ {
-					fld := modelTyp.Field(fldIx)
-					fldName := fld.Name
-					fldType := fld.Type
-					tag, _ := parseTag(fld)
-
-					paramsCol := params.ColMap[fldName]
-					//lclCol := lclColMap
-					if paramsCol > r.Sheet.MaxCol {
-						log.Fatal("Column supplied in map is out of range")
-					}
-					// if a parameter column maps to the field
-					if paramsCol > 0 {
-						cell := r.GetCell(paramsCol - 1)
-						dbRecordPtr.Elem().Field(fldIx).Set(CellToType(cell, fldType, params))
-					} else {
-						switch {
-						case tag.IsMapConst:
-							constString := params.ConstMap[tag.ConstMapKey]
-							// trying to convert empty strings to numbers in csv_to_gorm will bomb!
-							if constString == "" && fldType.Name() != "string" {
-								return fmt.Errorf("tag constant: " + tag.ConstMapKey + " missing for sheet:  " + sh.Name + ". ")
-							} else {
-								dbRecordPtr.Elem().Field(fldIx).Set(csv_to_gorm.StringToType(constString, fldType, csvParams))
-							}
-						case tag.IsIntColsHead:
-							dbRecordPtr.Elem().Field(fldIx).Set(csv_to_gorm.StringToType(intColHdg, fldType, csvParams))
-						case tag.IsIntColsValue:
-							dbRecordPtr.Elem().Field(fldIx).Set(CellToType(r.GetCell(lclColMap[intColHdg]-1), fldType, params))
-						case tag.HasColanme:
-							if lclColMap[tag.Colname] == 0 {
-								fmt.Println("Could not find column header " + tag.Colname + " in sheet: " + sh.Name)
-								return fmt.Errorf("Could not find column header " + tag.Colname + " in sheet: " + sh.Name)
-							}
-							dbRecordPtr.Elem().Field(fldIx).Set(CellToType(r.GetCell(lclColMap[tag.Colname]-1), fldType, params))
-						}
-					}
-				}
-				// add the record to the slice of records
-				objSlice = reflect.Append(objSlice, dbRecordPtr.Elem())
-			}
-
-		} else if hasMelt && !hasIntCols {
-			for _, meltColHdg := range meltColHdgs {
-				// create the new item to add to the database
-				dbRecordPtr = reflect.New(modelTyp)
-
-				// for each field in the model
-				for fldIx := 0; fldIx < modelNumFlds; fldIx++ {
-					fld := modelTyp.Field(fldIx)
-					fldName := fld.Name
-					fldType := fld.Type
-					tag, _ := parseTag(fld)
-
-					paramsCol := params.ColMap[fldName]
-					if paramsCol > r.Sheet.MaxCol {
-						log.Fatal("Column supplied in map is out of range")
-					}
-					// if a parameter column maps to the field
-					if paramsCol > 0 {
-						cell := r.GetCell(paramsCol - 1)
-						dbRecordPtr.Elem().Field(fldIx).Set(CellToType(cell, fldType, params))
-					} else {
-						switch {
-						case tag.IsMapConst:
-							constString := params.ConstMap[tag.ConstMapKey]
-							// trying to convert empty strings to numbers in csv_to_gorm will bomb!
-							if constString == "" && fldType.Name() != "string" {
-								return fmt.Errorf("tag constant: " + tag.ConstMapKey + " missing for sheet:  " + sh.Name + ". ")
-							} else {
-								dbRecordPtr.Elem().Field(fldIx).Set(csv_to_gorm.StringToType(constString, fldType, csvParams))
-							}
-						case tag.IsMeltHead:
-							dbRecordPtr.Elem().Field(fldIx).Set(csv_to_gorm.StringToType(meltColHdg, fldType, csvParams))
-						case tag.IsMeltValue:
-							dbRecordPtr.Elem().Field(fldIx).Set(CellToType(r.GetCell(lclColMap[meltColHdg]-1), fldType, params))
-						case tag.HasColanme:
-							if lclColMap[tag.Colname] == 0 {
-								fmt.Println("Could not find column header " + tag.Colname + " in sheet: " + sh.Name)
-								return fmt.Errorf("Could not find column header " + tag.Colname + " in sheet: " + sh.Name)
-							}
-							dbRecordPtr.Elem().Field(fldIx).Set(CellToType(r.GetCell(lclColMap[tag.Colname]-1), fldType, params))
-						}
-					}
-				}
-				// add the record to the slice of records
-				// objArry.Index(recordIx).Set(reflect.ValueOf(dbRecordPtr.Elem().Interface()))
-				objSlice = reflect.Append(objSlice, dbRecordPtr.Elem())
-			}
-
-		} else if hasMelt && hasIntCols {
-			for _, intColHdg := range intColHdgs {
-				for _, meltColHdg := range meltColHdgs {
-					// create the new item to add to the database
-					dbRecordPtr = reflect.New(modelTyp)
-
-					// for each field in the model
-					for fldIx := 0; fldIx < modelNumFlds; fldIx++ {
-						fld := modelTyp.Field(fldIx)
-						fldName := fld.Name
-						fldType := fld.Type
-						tag, _ := parseTag(fld)
-
-						paramsCol := params.ColMap[fldName]
-						if paramsCol > r.Sheet.MaxCol {
-							log.Fatal("Column supplied in map is out of range")
-						}
-						// if a parameter column maps to the field
-						if paramsCol > 0 {
-							cell := r.GetCell(paramsCol - 1)
-							dbRecordPtr.Elem().Field(fldIx).Set(CellToType(cell, fldType, params))
-						} else {
-							switch {
-							case tag.IsMapConst:
-								constString := params.ConstMap[tag.ConstMapKey]
-								// trying to convert empty strings to numbers in csv_to_gorm will bomb!
-								if constString == "" && fldType.Name() != "string" {
-									return fmt.Errorf("tag constant: " + tag.ConstMapKey + " missing for sheet:  " + sh.Name + ". ")
-								} else {
-									dbRecordPtr.Elem().Field(fldIx).Set(csv_to_gorm.StringToType(constString, fldType, csvParams))
-								}
-							case tag.IsMeltHead:
-								dbRecordPtr.Elem().Field(fldIx).Set(csv_to_gorm.StringToType(meltColHdg, fldType, csvParams))
-							case tag.IsMeltValue:
-								dbRecordPtr.Elem().Field(fldIx).Set(CellToType(r.GetCell(lclColMap[meltColHdg]-1), fldType, params))
-							case tag.IsIntColsHead:
-								dbRecordPtr.Elem().Field(fldIx).Set(csv_to_gorm.StringToType(intColHdg, fldType, csvParams))
-							case tag.IsIntColsValue:
-								dbRecordPtr.Elem().Field(fldIx).Set(CellToType(r.GetCell(lclColMap[intColHdg]-1), fldType, params))
-							case tag.HasColanme:
-								if lclColMap[tag.Colname] == 0 {
-									fmt.Println("Could not find column header " + tag.Colname + " in sheet: " + sh.Name)
-									return fmt.Errorf("Could not find column header " + tag.Colname + " in sheet: " + sh.Name)
-								}
-								dbRecordPtr.Elem().Field(fldIx).Set(CellToType(r.GetCell(lclColMap[tag.Colname]-1), fldType, params))
-							}
-						}
-					}
-					// add the record to the slice of records
-					// objArry.Index(recordIx).Set(reflect.ValueOf(dbRecordPtr.Elem().Interface()))
-					objSlice = reflect.Append(objSlice, dbRecordPtr.Elem())
-				}
+	rows := src.Rows()
+	for rows.Next() {
+		row := rows.Row()
+		// Get headings from first row if necessary
+		if sp == nil {
+			sp, err = plan.bind(src, sourceRow(src, row), params, csvParams)
+			if err != nil {
+				return objSlice.Interface(), sp, err
 			}
-
-		} else {
-			// for each field in the model
-			for fldIx := 0; fldIx < modelNumFlds; fldIx++ {
-				fld := modelTyp.Field(fldIx)
-				fldName := fld.Name
-				fldType := fld.Type
-				tag, _ := parseTag(fld)
-
-				paramsCol := params.ColMap[fldName]
-				//lclCol := lclColMap
-				if paramsCol > r.Sheet.MaxCol {
-					log.Fatal("Column supplied in map is out of range")
-				}
-				// if a parameter column maps to the field
-				if paramsCol > 0 {
-					cell := r.GetCell(paramsCol - 1)
-					dbRecordPtr.Elem().Field(fldIx).Set(CellToType(cell, fldType, params))
-				} else {
-					switch {
-					case tag.IsMapConst:
-						constString := params.ConstMap[tag.ConstMapKey]
-						// trying to convert empty strings to numbers in csv_to_gorm will bomb!
-						if constString == "" && fldType.Name() != "string" {
-							return fmt.Errorf("tag constant: " + tag.ConstMapKey + " missing for sheet:  " + sh.Name + ". ")
-						} else {
-							dbRecordPtr.Elem().Field(fldIx).Set(csv_to_gorm.StringToType(constString, fldType, csvParams))
-						}
-					case tag.HasColanme:
-						if lclColMap[tag.Colname] == 0 {
-							fmt.Println("Could not find column header " + tag.Colname + " in sheet: " + sh.Name)
-							return fmt.Errorf("Could not find column header " + tag.Colname + " in sheet: " + sh.Name)
-						}
-						dbRecordPtr.Elem().Field(fldIx).Set(CellToType(r.GetCell(lclColMap[tag.Colname]-1), fldType, params))
-					}
-				}
+			sp.hdgRowNum = row
+			if maxRow > 0 {
+				objSlice = reflect.MakeSlice(objSlice.Type(), 0, sp.recordsPerRow()*(maxRow-1))
 			}
-			// add the record to the slice of records
-			// objArry.Index(recordIx).Set(reflect.ValueOf(dbRecordPtr.Elem().Interface()))
-			objSlice = reflect.Append(objSlice, dbRecordPtr.Elem())
+			continue
+		}
+		objSlice, err = sp.appendRecords(objSlice, src, row)
+		if err != nil {
+			return objSlice.Interface(), sp, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return objSlice.Interface(), sp, err
+	}
+	if sp != nil && len(sp.fkPending) > 0 {
+		if err := sp.resolveForeignKeys(objSlice); err != nil {
+			return objSlice.Interface(), sp, err
 		}
-
-		return nil
-	})
-	if err != nil {
-		return objSlice.Interface(), err
 	}
 
-	return objSlice.Interface(), nil
+	return objSlice.Interface(), sp, nil
 
 }
 
 // get the first row of a worksheet, whixch is assumed to be the column heading names
 func GetHeadings(fileName string, sheetName string) ([]string, error) {
-	var headings []string
-	wb, err := xlsx.OpenFile(fileName)
+	w, err := Open(fileName)
+	if err != nil {
+		return []string{""}, err
+	}
+	defer w.Close()
+	headings, err := w.Headings(sheetName, 1)
 	if err != nil {
-		return []string{""}, errors.New("could not open file: " + fileName)
+		return []string{""}, err
 	}
+	return headings, nil
+}
 
+// as GetHeadings, for an already open workbook.  The sheet is left open
+func WorkbookHeadings(wb *xlsx.File, sheetName string) ([]string, error) {
+	var headings []string
 	sh, ok := wb.Sheet[sheetName]
 	if !ok {
 		return []string{""}, errors.New("could find sheet:  " + sheetName)
 	}
-	defer sh.Close()
 
-	row1, _ := sh.Row(0)
+	row1, err := sh.Row(0)
+	if err != nil {
+		return []string{""}, fmt.Errorf("could not read headings of sheet: %v. %w", sheetName, err)
+	}
 
 	row1.ForEachCell(func(c *xlsx.Cell) error {
 		heading := c.String()
@@ -430,23 +664,21 @@ func GetHeadings(fileName string, sheetName string) ([]string, error) {
 	return headings, nil
 }
 
-func mapHeadingToCol(r *xlsx.Row) map[string]int {
-	colMap := make(map[string]int, r.Sheet.MaxCol)
+func mapHeadingToCol(hdgRow []CellValue) map[string]int {
+	colMap := make(map[string]int, len(hdgRow))
 
-	r.ForEachCell(func(c *xlsx.Cell) error {
-		header := c.String()
+	for colNo, c := range hdgRow {
+		header := c.Display()
 		if header != "" {
-			ColNo, _ := c.GetCoordinates()
-			colMap[header] = ColNo + 1
+			colMap[header] = colNo + 1
 		}
-		return nil
-	})
+	}
 	return colMap
 }
 
-func getIntCols(r *xlsx.Row) []string {
+func getIntCols(hdgRow []CellValue) []string {
 	var intCols []string
-	r.ForEachCell(func(c *xlsx.Cell) error {
+	for _, c := range hdgRow {
 		// converts strings.ParseFloat to int
 		f, err := c.Float()
 		if err == nil {
@@ -454,164 +686,330 @@ func getIntCols(r *xlsx.Row) []string {
 				intCols = append(intCols, c.Value)
 			}
 		}
-		return nil
-	})
+	}
 	return intCols
 }
 
-func getMeltCols(r *xlsx.Row, colMap map[string]int, definedCols []string, ignoreHdgs []string, hasIntCols bool, intColHdgs []string) []string {
-	var mappedCols []string
+// fixedCols maps fieldnames to column numbers (starting at 1). These columns are never melted
+func getMeltCols(hdgRow []CellValue, fixedCols map[string]int, definedCols []string, ignoreHdgs []string, hasIntCols bool, intColHdgs []string) []string {
 	var meltCols []string
-	r.ForEachCell(func(c *xlsx.Cell) error {
-		for mappedCol := range colMap {
-			mappedCols = append(mappedCols, mappedCol)
-		}
+cols:
+	for colNo, c := range hdgRow {
 		// check if column heading is
-		heading := c.String()
+		heading := c.Display()
 		if heading == "" {
-			return nil
+			continue
 		}
-		_, isMapped := find(mappedCols, heading)
-		if isMapped {
-			return nil
+		for _, fixedCol := range fixedCols {
+			if fixedCol == colNo+1 {
+				continue cols
+			}
 		}
 		_, isDefined := find(definedCols, heading)
 		if isDefined {
-			return nil
+			continue
 		}
 		_, isIgnored := find(ignoreHdgs, heading)
 		if isIgnored {
-			return nil
+			continue
 		}
 		_, isIntCol := find(intColHdgs, heading)
 		if isIntCol && hasIntCols {
-			return nil
+			continue
 		}
 
 		// if we've not exited by now, we are a melt column
 		meltCols = append(meltCols, heading)
-		return nil
-	})
+	}
 	return meltCols
 }
 
 func GetSheetNames(fileName string) ([]string, error) {
 	var sheets []string
-	wb, err := xlsx.OpenFile(fileName)
+	w, err := Open(fileName)
 	if err != nil {
-		return sheets, errors.New("could not open file: " + fileName)
+		return sheets, err
 	}
+	defer w.Close()
+	return w.SheetNames(), nil
+}
+
+// as GetSheetNames, for an already open workbook
+func WorkbookSheetNames(wb *xlsx.File) []string {
+	var sheets []string
 	for _, sh := range wb.Sheets {
 		sheets = append(sheets, sh.Name)
 	}
-	return sheets, nil
+	return sheets
 }
 
 func GetSheetNameMap(fileName string) (map[string]int, error) {
 	var sheetMap map[string]int
-	wb, err := xlsx.OpenFile(fileName)
+	w, err := Open(fileName)
 	if err != nil {
-		return sheetMap, errors.New("could not open file: " + fileName)
+		return sheetMap, err
 	}
+	defer w.Close()
+	return w.SheetIndex(), nil
+}
 
-	sheetMap = make(map[string]int, len(wb.Sheets))
+// as GetSheetNameMap, for an already open workbook
+func WorkbookSheetNameMap(wb *xlsx.File) map[string]int {
+	sheetMap := make(map[string]int, len(wb.Sheets))
 
 	for i, sh := range wb.Sheets {
 		sheetMap[sh.Name] = i
 	}
-	return sheetMap, nil
+	return sheetMap
+}
+
+// takes a cell and converts it to a reflect.Value of a given type (supplied as a reflect.Type)
+// used internally, but exposed as it may have uses elsewhere.  For an *xlsx.Cell, pass XlsxCellValue(c)
+func CellToType(cv CellValue, outType reflect.Type, params Params) reflect.Value {
+	result, err := cellToType(cv, outType, params)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return result
+}
+
+// converts a cell to a given type, returning an error if it cannot
+func cellToType(cv CellValue, outType reflect.Type, params Params) (reflect.Value, error) {
+	convert := converterFor(outType)
+	if convert == nil {
+		return reflect.Value{}, fmt.Errorf("CellToType has recieved a %v and does not kow how to handle it", outType)
+	}
+	return convert(cv, outType, params)
 }
 
-// takes an excel cell and converts it to a reflect.Value of a given type (supplied as a reflect.Type)
-// used internally, but exposed as it may have uses elsewhere
-func CellToType(c *xlsx.Cell, outType reflect.Type, params Params) reflect.Value {
-	var cellString string
+// converts a cell to a reflect.Value of outType
+type converter func(cv CellValue, outType reflect.Type, params Params) (reflect.Value, error)
+
+// picks the converter for a type.  returns nil if the type is not supported
+func converterFor(outType reflect.Type) converter {
+	// a time.Duration is an int64, so is picked out before its kind
+	if outType == durationType {
+		return cellToDuration
+	}
 	switch outType.Kind() {
 	case reflect.String:
-		cellString = c.Value
-		return reflect.ValueOf(cellString)
+		return cellToString
 	case reflect.Bool:
-		cellString = c.Value
-		var firstLetter string
-		if len(cellString) < 1 {
-			firstLetter = ""
-		} else {
-			firstLetter = cellString[0:1]
+		return cellToBool
+	case reflect.Int, reflect.Uint, reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8, reflect.Uint64, reflect.Uint32, reflect.Uint16, reflect.Uint8:
+		return cellToInt
+	case reflect.Float32, reflect.Float64:
+		return cellToFloat
+	case reflect.Ptr:
+		return pointerConverter(converterFor(outType.Elem()))
+	default:
+		switch outType.String() {
+		case "time.Time":
+			return cellToTime
 		}
-		if c.Bool() || strings.ContainsAny(firstLetter, "YyTt1") || strings.Contains(strings.ToLower(cellString), "true") || strings.Contains(strings.ToLower(cellString), "yes") {
-			return reflect.ValueOf(true)
-		} else {
-			return reflect.ValueOf(false)
+	}
+	return nil
+}
+
+// converts to a pointer to the type converted by elemConvert.  Empty cells are nil, so that nullable
+// columns are NULL rather than zero
+func pointerConverter(elemConvert converter) converter {
+	if elemConvert == nil {
+		return nil
+	}
+	return func(cv CellValue, outType reflect.Type, params Params) (reflect.Value, error) {
+		if cv.Kind == CellEmpty {
+			return reflect.Zero(outType), nil
 		}
-	case reflect.Int, reflect.Uint, reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8, reflect.Uint64, reflect.Uint32, reflect.Uint16, reflect.Uint8:
-		result := reflect.New(reflect.Type(outType))
+		elem, err := elemConvert(cv, outType.Elem(), params)
+		if err != nil {
+			return reflect.Zero(outType), err
+		}
+		ptr := reflect.New(outType.Elem())
+		ptr.Elem().Set(elem)
+		return ptr, nil
+	}
+}
 
-		i, err := c.Int64()
+func cellToString(cv CellValue, outType reflect.Type, params Params) (reflect.Value, error) {
+	return reflect.ValueOf(cv.Value).Convert(outType), nil
+}
+
+// reads the words of Params.TrueValues and FalseValues, or the defaults.  Anything else is an error
+func cellToBool(cv CellValue, outType reflect.Type, params Params) (reflect.Value, error) {
+	word := strings.TrimSpace(cv.Value)
+	if word == "" {
+		switch params.BlankBool {
+		case BlankIsTrue:
+			return reflect.ValueOf(true).Convert(outType), nil
+		case BlankIsError:
+			return reflect.Zero(outType), errors.New("CellToType could not convert an empty cell to bool")
+		}
+		return reflect.ValueOf(false).Convert(outType), nil
+	}
+	if cv.Kind == CellBool || cv.Kind == CellNumber {
+		switch word {
+		case "1":
+			return reflect.ValueOf(true).Convert(outType), nil
+		case "0":
+			return reflect.ValueOf(false).Convert(outType), nil
+		}
+	}
+	trueValues, falseValues := params.TrueValues, params.FalseValues
+	if trueValues == nil {
+		trueValues = DefaultTrueValues
+	}
+	if falseValues == nil {
+		falseValues = DefaultFalseValues
+	}
+	for _, t := range trueValues {
+		if strings.EqualFold(word, strings.TrimSpace(t)) {
+			return reflect.ValueOf(true).Convert(outType), nil
+		}
+	}
+	for _, f := range falseValues {
+		if strings.EqualFold(word, strings.TrimSpace(f)) {
+			return reflect.ValueOf(false).Convert(outType), nil
+		}
+	}
+	return reflect.Zero(outType), errors.New("CellToType could not convert " + cv.Value + " to bool. should be one of " +
+		strings.Join(trueValues, ", ") + " or " + strings.Join(falseValues, ", "))
+}
+
+func cellToInt(cv CellValue, outType reflect.Type, params Params) (reflect.Value, error) {
+	result := reflect.New(outType).Elem()
+
+	digits := cv.Value
+	var err error
+	if isTextNumber(cv, params) {
+		digits, err = textToWholeNumber(cv, params)
+	}
+	if err == nil {
+		err = setInteger(result, digits)
+	}
+	if err != nil {
+		return result, fmt.Errorf("CellToType could not convert "+cv.Value+" to integer: %w", err)
+	}
+	return result, nil
+}
+
+// sets an int or uint from the decimal digits of a whole number, checking it fits
+func setInteger(v reflect.Value, digits string) error {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int64, reflect.Int32, reflect.Int16, reflect.Int8:
+		i, err := strconv.ParseInt(digits, 10, 64)
 		if err != nil {
-			log.Fatal("CellToType could not convert "+c.Value+" to integer: ", err)
-		} else {
-			if outType.Kind() == reflect.Int || outType.Kind() == reflect.Int64 || outType.Kind() == reflect.Int32 || outType.Kind() == reflect.Int16 || outType.Kind() == reflect.Int8 {
-				result.Elem().SetInt(int64(i))
-				return result.Elem()
+			return err
+		}
+		if v.OverflowInt(i) {
+			return errors.New("out of range for " + v.Type().String())
+		}
+		v.SetInt(i)
+	default:
+		if strings.HasPrefix(digits, "-") {
+			return errors.New("negative number for " + v.Type().String())
+		}
+		u, err := strconv.ParseUint(digits, 10, 64)
+		if err != nil {
+			return err
+		}
+		if v.OverflowUint(u) {
+			return errors.New("out of range for " + v.Type().String())
+		}
+		v.SetUint(u)
+	}
+	return nil
+}
+
+func cellToFloat(cv CellValue, outType reflect.Type, params Params) (reflect.Value, error) {
+	resultPtr := reflect.New(outType)
+
+	// Postgres numeric doesnt support inf yet.  Convert to MaxFloat
+	// numbers never display as inf, so are not formatted just to check
+	strVal := cv.Value
+	if cv.Kind != CellNumber {
+		strVal = cv.Display()
+	}
+	if strings.Contains(strVal, "inf") {
+
+		if strings.Contains(strVal, "-") {
+			if outType.Kind() == reflect.Float64 {
+				f := -math.MaxFloat64
+				resultPtr.Elem().SetFloat(f)
 			} else {
-				result.Elem().SetUint(uint64(i))
-				return result.Elem()
+				f := -math.MaxFloat32
+				resultPtr.Elem().SetFloat(f)
 			}
-		}
-	case reflect.Float32, reflect.Float64:
-		resultPtr := reflect.New(reflect.Type(outType))
-
-		// Postgres numeric doesnt support inf yet.  Convert to MaxFloat
-		strVal := c.String()
-		if strings.Contains(strVal, "inf") {
-
-			if strings.Contains(strVal, "-") {
-				if outType.Kind() == reflect.Float64 {
-					f := -math.MaxFloat64
-					resultPtr.Elem().SetFloat(f)
-				} else {
-					f := -math.MaxFloat32
-					resultPtr.Elem().SetFloat(f)
-				}
+		} else {
+			if outType.Kind() == reflect.Float64 {
+				f := math.MaxFloat64
+				resultPtr.Elem().SetFloat(f)
 			} else {
-				if outType.Kind() == reflect.Float64 {
-					f := math.MaxFloat64
-					resultPtr.Elem().SetFloat(f)
-				} else {
-					f := math.MaxFloat32
-					resultPtr.Elem().SetFloat(f)
-				}
+				f := math.MaxFloat32
+				resultPtr.Elem().SetFloat(f)
 			}
-			return resultPtr.Elem()
 		}
+		return resultPtr.Elem(), nil
+	}
 
-		f, err := c.Float()
-		if err != nil {
-			if params.ErrorOnNaN {
-				log.Fatal("CellToType could not convert "+c.Value+" to float: ", err)
-			}
-			// if it's not a
-			f = math.NaN()
+	var f float64
+	var err error
+	if isTextNumber(cv, params) {
+		f, err = textToNumber(cv, params)
+	} else {
+		f, err = cv.Float()
+	}
+	if err != nil {
+		if params.ErrorOnNaN {
+			return resultPtr.Elem(), fmt.Errorf("CellToType could not convert "+cv.Value+" to float: %w", err)
 		}
+		// if it's not a
+		f = math.NaN()
+	}
 
-		resultPtr.Elem().SetFloat(f)
-		return resultPtr.Elem()
-	default:
-		switch outType.String() {
-		case "time.Time":
-			dt, err := c.GetTime(false)
-			if err != nil {
-				log.Fatal("CellToType could not convert "+c.Value+" to date/time: ", err)
-			}
-			return reflect.ValueOf(dt)
-
-		default:
-			log.Fatal("CellToType has recieved a ", outType, " and does not kow how to handle it")
+	resultPtr.Elem().SetFloat(f)
+	return resultPtr.Elem(), nil
+}
 
+// dates are read as the wall clock time in Params.Location.  Text is read with Params.DateLayouts
+func cellToTime(cv CellValue, outType reflect.Type, params Params) (reflect.Value, error) {
+	loc := paramsLocation(params)
+	switch {
+	case cv.Kind == CellDate:
+		return reflect.ValueOf(inLocation(cv.Time, loc)), nil
+	case cv.Kind == CellString, cv.fromText:
+		t, err := parseTextTime(cv.Value, params)
+		if err == nil {
+			return reflect.ValueOf(t), nil
 		}
+		// text holding a plain number is a serial date, like numbers guessed from text
+		f, ferr := strconv.ParseFloat(strings.TrimSpace(cv.Value), 64)
+		if ferr != nil {
+			return reflect.Zero(outType), fmt.Errorf("CellToType could not convert "+cv.Value+" to date/time: %w", err)
+		}
+		return reflect.ValueOf(inLocation(xlsx.TimeFromExcelTime(f, cv.date1904), loc)), nil
+	}
+	f, err := cv.Float()
+	if err != nil {
+		return reflect.Zero(outType), fmt.Errorf("CellToType could not convert "+cv.Value+" to date/time: %w", err)
+	}
+	return reflect.ValueOf(inLocation(xlsx.TimeFromExcelTime(f, cv.date1904), loc)), nil
+}
 
+// times are fractions of a day, as stored by excel.  Text is read as h:mm:ss or 1h30m
+func cellToDuration(cv CellValue, outType reflect.Type, params Params) (reflect.Value, error) {
+	if cv.Kind == CellString {
+		d, err := parseTextDuration(cv.Value)
+		if err != nil {
+			return reflect.Zero(outType), fmt.Errorf("CellToType could not convert "+cv.Value+" to duration: %w", err)
+		}
+		return reflect.ValueOf(d).Convert(outType), nil
+	}
+	f, err := cv.Float()
+	if err != nil {
+		return reflect.Zero(outType), fmt.Errorf("CellToType could not convert "+cv.Value+" to duration: %w", err)
 	}
-	return reflect.ValueOf(errors.New("CellToType could not convert type " + outType.Name()))
+	return reflect.ValueOf(serialToDuration(f)).Convert(outType), nil
 }
 
 // Find takes a slice and looks for an element in it. If found it will
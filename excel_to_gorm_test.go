@@ -0,0 +1,393 @@
+package excel_to_gorm
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/tealeg/xlsx/v3"
+)
+
+type fixedColApple struct {
+	Variety string  `xtg:"colref:B"`
+	Weight  float64 `xtg:"colidx:3"`
+	Grower  string  `xtg:"colref:A"`
+}
+
+func TestFixedColumnsWithoutHeadings(t *testing.T) {
+	wb := mkBook(t, map[string][][]interface{}{"s": {
+		{"Smith", "Gala", 1.5},
+		{"Jones", "Fuji", 2.25},
+	}})
+	out, err := WorksheetToSlice(wb.Sheet["s"], &fixedColApple{}, Params{FirstRowHasData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]fixedColApple)
+	want := []fixedColApple{{"Gala", 1.5, "Smith"}, {"Fuji", 2.25, "Jones"}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestColRefMapOverridesTags(t *testing.T) {
+	rows := [][]string{{"Smith", "Gala", "1.5"}}
+	params := Params{FirstRowHasData: true, ColRefMap: map[string]string{"Grower": "B", "Variety": "A"}}
+	out, err := SourceToSlice(NewGridSource("s", rows), &fixedColApple{}, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.([]fixedColApple)[0]; got.Grower != "Gala" || got.Variety != "Smith" {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestInvalidColRef(t *testing.T) {
+	type bad struct {
+		Name string `xtg:"colref:3"`
+	}
+	rows := [][]string{{"a"}}
+	if _, err := SourceToSlice(NewGridSource("s", rows), &bad{}, Params{FirstRowHasData: true}); err == nil {
+		t.Error("expected an error for colref:3")
+	}
+}
+
+type metaYield struct {
+	Name   string  `xtg:"col:Name"`
+	Year   string  `xtg:"melt:colname"`
+	Yield  float64 `xtg:"melt:value"`
+	Row    int     `xtg:"meta:row"`
+	Sheet  string  `xtg:"meta:sheet"`
+	File   string  `xtg:"meta:file"`
+	Col    string  `xtg:"meta:col"`
+	ColRef string  `xtg:"meta:colref"`
+	Cell   string  `xtg:"meta:cell"`
+}
+
+func TestMetaTags(t *testing.T) {
+	rows := [][]string{{"Name", "2019", "2020"}, {"Gala", "1.5", "2.5"}}
+	out, err := SourceToSlice(NewGridSource("yields", rows), &metaYield{}, Params{FileName: "orchard.csv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]metaYield)
+	want := []metaYield{
+		{"Gala", "2019", 1.5, 2, "yields", "orchard.csv", "2019", "B", "B2"},
+		{"Gala", "2020", 2.5, 2, "yields", "orchard.csv", "2020", "C", "C2"},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestMetaCellWithoutMelt(t *testing.T) {
+	type plainMeta struct {
+		Name string `xtg:"col:Name"`
+		Cell string `xtg:"meta:cell"`
+	}
+	rows := [][]string{{"Id", "Name"}, {"1", "Gala"}}
+	out, err := SourceToSlice(NewGridSource("s", rows), &plainMeta{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.([]plainMeta)[0]; got.Cell != "B2" {
+		t.Errorf("got %+v, want the first cell read, B2", got)
+	}
+}
+
+func TestUnknownMeta(t *testing.T) {
+	type badMeta struct {
+		Where string `xtg:"meta:planet"`
+	}
+	rows := [][]string{{"Name"}, {"Gala"}}
+	if _, err := SourceToSlice(NewGridSource("s", rows), &badMeta{}, Params{}); err == nil {
+		t.Error("expected an error for meta:planet")
+	}
+}
+
+func appleBook(t *testing.T) []byte {
+	return mkBytes(t, map[string][][]interface{}{
+		"apples": {{"Variety", "Weight"}, {"Gala", 3}, {"Fuji", 4}},
+		"pears":  {{"Variety"}},
+	})
+}
+
+func TestBytesAndReaderToSlice(t *testing.T) {
+	data := appleBook(t)
+	out, err := BytesToSlice(data, "apples", &sheetApple{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.([]sheetApple); len(got) != 2 || got[1] != (sheetApple{"Fuji", 4}) {
+		t.Errorf("BytesToSlice got %+v", got)
+	}
+	out, err = ReaderToSlice(bytes.NewReader(data), int64(len(data)), "apples", &sheetApple{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.([]sheetApple); len(got) != 2 {
+		t.Errorf("ReaderToSlice got %+v", got)
+	}
+	if _, err := BytesToSlice([]byte("not a workbook"), "apples", &sheetApple{}, Params{}); err == nil {
+		t.Error("expected an error for bytes which are not a workbook")
+	}
+}
+
+func TestWorkbookMetadata(t *testing.T) {
+	wb := mkBook(t, map[string][][]interface{}{"apples": {{"Variety", "Weight"}, {"Gala", 3}}})
+	if got := WorkbookSheetNames(wb); len(got) != 1 || got[0] != "apples" {
+		t.Errorf("WorkbookSheetNames got %v", got)
+	}
+	if got := WorkbookSheetNameMap(wb); got["apples"] != 0 || len(got) != 1 {
+		t.Errorf("WorkbookSheetNameMap got %v", got)
+	}
+	if got, err := WorkbookHeadings(wb, "apples"); err != nil || len(got) != 2 || got[1] != "Weight" {
+		t.Errorf("WorkbookHeadings got %v, %v", got, err)
+	}
+	if _, err := WorkbookHeadings(wb, "pears"); err == nil {
+		t.Error("expected an error for a missing sheet")
+	}
+}
+
+type errorCellRow struct {
+	Name  string   `xtg:"col:Name"`
+	A     float64  `xtg:"col:A"`
+	Zero  *float64 `xtg:"col:A,onerror:zero"`
+	Label string   `xtg:"col:Label"`
+}
+
+func errorCellBook() map[string][][]interface{} {
+	return map[string][][]interface{}{"S": {
+		{"Name", "A", "Label"},
+		{"x", fml("1/0"), "ok"},
+		{"y", 2.5, fml("#N/A")},
+	}}
+}
+
+// the same errors as saved by excel, read without evaluating formulas
+func cachedErrorCellBook() map[string][][]interface{} {
+	return map[string][][]interface{}{"S": {
+		{"Name", "A", "Label"},
+		{"x", xerr("#DIV/0!"), "ok"},
+		{"y", 2.5, xerr("#N/A")},
+	}}
+}
+
+func TestOnCellError(t *testing.T) {
+	testOnCellError(t, mkBook(t, errorCellBook()).Sheet["S"], true)
+}
+
+func TestOnCachedCellError(t *testing.T) {
+	sh := mkBook(t, cachedErrorCellBook()).Sheet["S"]
+	c, err := sh.Cell(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cv := XlsxCellValue(c); cv.Kind != CellError || cv.Value != "#DIV/0!" {
+		t.Fatalf("got %+v, expected an error cell", cv)
+	}
+	testOnCellError(t, sh, false)
+}
+
+func testOnCellError(t *testing.T, sh *xlsx.Sheet, evaluate bool) {
+	tests := []struct {
+		policy ErrorPolicy
+		names  string // of the records read
+		labelY string
+		nanA   bool
+	}{
+		{OnErrorValue, "xy", "#N/A", true},
+		{OnErrorNull, "xy", "", false},
+		{OnErrorZero, "xy", "", false},
+		{OnErrorNaN, "xy", "", true},
+		{OnErrorSkipRecord, "", "", false},
+	}
+	for _, tt := range tests {
+		report := &Report{}
+		out, err := WorksheetToSlice(sh, &errorCellRow{}, Params{EvaluateFormulas: evaluate, OnCellError: tt.policy, Report: report})
+		if err != nil {
+			t.Errorf("policy %v: %v", tt.policy, err)
+			continue
+		}
+		got := out.([]errorCellRow)
+		names := ""
+		for _, rec := range got {
+			names += rec.Name
+		}
+		if names != tt.names {
+			t.Errorf("policy %v: got records %q, want %q", tt.policy, names, tt.names)
+			continue
+		}
+		if len(got) == 2 {
+			if math.IsNaN(got[0].A) != tt.nanA || got[1].Label != tt.labelY {
+				t.Errorf("policy %v: got %+v", tt.policy, got)
+			}
+			// onerror:zero overrides the policy
+			if got[0].Zero == nil || *got[0].Zero != 0 {
+				t.Errorf("policy %v: onerror:zero got %v", tt.policy, got[0].Zero)
+			}
+		}
+		if issues := report.Issues(); len(issues) == 0 || issues[0].Code != IssueCellError || issues[0].Value != "#DIV/0!" {
+			t.Errorf("policy %v: got issues %v", tt.policy, issues)
+		}
+	}
+
+	if _, err := WorksheetToSlice(sh, &errorCellRow{}, Params{EvaluateFormulas: evaluate, OnCellError: OnErrorFail}); err == nil {
+		t.Error("OnErrorFail: expected an error")
+	}
+}
+
+func TestInvalidOnErrorTag(t *testing.T) {
+	type badOnError struct {
+		A float64 `xtg:"col:A,onerror:ignore"`
+	}
+	if _, err := SourceToSlice(NewGridSource("s", [][]string{{"A"}}), &badOnError{}, Params{}); err == nil {
+		t.Error("expected an error for onerror:ignore")
+	}
+}
+
+type boolRow struct {
+	Default  bool  `xtg:"col:Default"`
+	French   bool  `xtg:"col:French,bool:true=Oui;Ja,false=Non;Nein,blank=error"`
+	Optional *bool `xtg:"col:Optional"`
+}
+
+func TestBools(t *testing.T) {
+	rows := [][]string{{"Default", "French", "Optional"}, {"Yes", "oui", "1"}, {"", "Nein", ""}, {" off ", "JA", "f"}}
+	out, err := SourceToSlice(NewGridSource("s", rows), &boolRow{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]boolRow)
+	want := []struct {
+		deflt, french bool
+		optional      *bool
+	}{{true, true, &[]bool{true}[0]}, {false, false, nil}, {false, true, &[]bool{false}[0]}}
+	for i, w := range want {
+		r := got[i]
+		if r.Default != w.deflt || r.French != w.french || (r.Optional == nil) != (w.optional == nil) || (r.Optional != nil && *r.Optional != *w.optional) {
+			t.Errorf("row %v got %+v", i+2, r)
+		}
+	}
+
+	// xlsx bool and number cells
+	wb := mkBook(t, map[string][][]interface{}{"s": {{"Default", "French", "Optional"}, {true, "non", true}, {1, "oui", nil}, {0, "oui", false}}})
+	out, err = WorksheetToSlice(wb.Sheet["s"], &boolRow{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.([]boolRow); !got[0].Default || !got[1].Default || got[2].Default || !*got[0].Optional || got[1].Optional != nil || *got[2].Optional {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestInvalidBools(t *testing.T) {
+	for _, row := range [][]string{{"Tomato", "oui"}, {"10", "oui"}, {"Not true", "oui"}, {"yes", ""}, {"yes", "yes"}} {
+		rows := [][]string{{"Default", "French"}, row}
+		if out, err := SourceToSlice(NewGridSource("s", rows), &boolRow{}, Params{}); err == nil {
+			t.Errorf("%q got %+v, expected an error", row, out)
+		}
+	}
+}
+
+func TestBoolParams(t *testing.T) {
+	type flag struct {
+		Flag bool `xtg:"col:Flag"`
+	}
+	rows := [][]string{{"Flag"}, {""}, {"x"}, {"-"}}
+	out, err := SourceToSlice(NewGridSource("s", rows), &flag{}, Params{BlankBool: BlankIsTrue, TrueValues: []string{"x"}, FalseValues: []string{"-"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.([]flag); !got[0].Flag || !got[1].Flag || got[2].Flag {
+		t.Errorf("got %+v", got)
+	}
+	// the default words are replaced rather than added to
+	if _, err := SourceToSlice(NewGridSource("s", [][]string{{"Flag"}, {"yes"}}), &flag{}, Params{TrueValues: []string{"x"}}); err == nil {
+		t.Error("expected an error for a default word replaced by Params.TrueValues")
+	}
+	if _, err := SourceToSlice(NewGridSource("s", [][]string{{"Flag"}, {""}}), &flag{}, Params{BlankBool: BlankIsError}); err == nil {
+		t.Error("expected an error for a blank cell with BlankIsError")
+	}
+}
+
+func TestInvalidBoolTag(t *testing.T) {
+	type badBool struct {
+		Flag bool `xtg:"col:Flag,bool:blank=maybe"`
+	}
+	if _, err := SourceToSlice(NewGridSource("s", [][]string{{"Flag"}, {"yes"}}), &badBool{}, Params{}); err == nil {
+		t.Error("expected an error for bool:blank=maybe")
+	}
+}
+
+type defaultRow struct {
+	Name   string     `xtg:"col:Name,fallback:Alt Name;Nick"`
+	Origin string     `xtg:"col:Origin,default:Unknown"`
+	Qty    *int       `xtg:"col:Qty,default:5"`
+	When   time.Time  `xtg:"col:When,default:2021-01-02"`
+	Ok     bool       `xtg:"col:Ok,bool:true=y,default:y"`
+	Grade  int        `xtg:"col:Grade" gorm:"default:7"`
+	Picked *time.Time `xtg:"col:Picked" gorm:"default:CURRENT_TIMESTAMP"`
+	Note   string     `xtg:"col:Note,transform:trim,default:none"`
+	Cause  string     `xtg:"melt:colname"`
+	Loss   float64    `xtg:"melt:value,default:-1,fallback:Base"`
+}
+
+func TestDefaultsAndFallbacks(t *testing.T) {
+	rows := [][]string{
+		{"Name", "Alt Name", "Nick", "Origin", "Qty", "When", "Ok", "Grade", "Picked", "Note", "Base", "Scab", "Rot"},
+		{"Gala", "x", "", "UK", "1", "2020-05-05", "", "", "", "  ", "9", "1", ""},
+		{"", "Fuji", "f", "", "", "", "", "3", "", "ripe", "", "", "2"},
+		{"", "", "Jazz", "", "", "", "", "", "", "", "", "", ""},
+	}
+	out, err := SourceToSlice(NewGridSource("s", rows), &defaultRow{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]defaultRow)
+	if len(got) != 6 {
+		t.Fatalf("got %+v", got)
+	}
+	jan2 := time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name, origin, note string
+		qty, grade         int
+		when               time.Time
+		loss               float64
+	}{
+		{"Gala", "UK", "none", 1, 7, time.Date(2020, 5, 5, 0, 0, 0, 0, time.UTC), 1},
+		{"Gala", "UK", "none", 1, 7, time.Date(2020, 5, 5, 0, 0, 0, 0, time.UTC), 9},
+		{"Fuji", "Unknown", "ripe", 5, 3, jan2, -1},
+		{"Fuji", "Unknown", "ripe", 5, 3, jan2, 2},
+		{"Jazz", "Unknown", "none", 5, 7, jan2, -1},
+		{"Jazz", "Unknown", "none", 5, 7, jan2, -1},
+	}
+	for i, w := range tests {
+		r := got[i]
+		if r.Name != w.name || r.Origin != w.origin || r.Note != w.note || r.Qty == nil || *r.Qty != w.qty ||
+			r.Grade != w.grade || !r.When.Equal(w.when) || r.Loss != w.loss || !r.Ok || r.Picked != nil {
+			t.Errorf("record %v got %+v", i, r)
+		}
+	}
+	// each record has its own copy of a pointer default
+	if got[2].Qty == got[4].Qty {
+		t.Error("expected records not to share the default of a pointer field")
+	}
+}
+
+func TestInvalidDefaultsAndFallbacks(t *testing.T) {
+	rows := [][]string{{"Name"}, {"Gala"}}
+	type badDefault struct {
+		Qty int `xtg:"col:Name,default:abc"`
+	}
+	if _, err := SourceToSlice(NewGridSource("s", rows), &badDefault{}, Params{}); err == nil {
+		t.Error("expected an error for a default which does not suit the field")
+	}
+	type missingFallback struct {
+		Name string `xtg:"col:Name,fallback:Nick"`
+	}
+	if _, err := SourceToSlice(NewGridSource("s", rows), &missingFallback{}, Params{}); err == nil {
+		t.Error("expected an error for a fallback heading missing from the sheet")
+	}
+}
@@ -0,0 +1,197 @@
+package excel_to_gorm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/tealeg/xlsx/v3"
+	"gorm.io/gorm"
+)
+
+// values looked up in a parent table per query
+const fkBatchSize = 500
+
+// ForeignKey is a fk: tag, eg. fk:Country.Name->ID;create
+type ForeignKey struct {
+	Model  string // the parent model, eg. Country.  Its table is named by the naming strategy of Params.DB
+	Column string // the field of the parent matched with the text of the cell, eg. Name
+	Key    string // the field of the parent stored in the field, usually ID
+	Create bool   // parents missing from the table are created
+}
+
+// parses the parameter of a fk: tag
+func parseForeignKey(param string) (*ForeignKey, error) {
+	options := strings.Split(param, ";")
+	fk := &ForeignKey{Key: "ID"}
+	target := strings.TrimSpace(options[0])
+	if i := strings.Index(target, "->"); i >= 0 {
+		fk.Key = strings.TrimSpace(target[i+2:])
+		target = target[:i]
+	}
+	dot := strings.LastIndex(target, ".")
+	if dot < 1 || dot == len(target)-1 || fk.Key == "" {
+		return nil, errors.New("should be in the form fk:<Model>.<Field>-><KeyField>;create")
+	}
+	fk.Model, fk.Column = strings.TrimSpace(target[:dot]), strings.TrimSpace(target[dot+1:])
+	for _, option := range options[1:] {
+		if strings.TrimSpace(option) != "create" {
+			return nil, errors.New("unknown option " + option + ". should be in the form fk:<Model>.<Field>-><KeyField>;create")
+		}
+		fk.Create = true
+	}
+	return fk, nil
+}
+
+// the text of a cell to be resolved to the key of its parent once all records are read
+type fkPending struct {
+	record int
+	fldIx  int
+	text   string
+	row    int
+	col    int
+}
+
+// resolves the fk: fields of the records, looking up the parents of each field in batches.
+// Missing parents are created if the tag says so, otherwise Params.OnUnmapped applies
+func (sp *sheetPlan) resolveForeignKeys(records reflect.Value) error {
+	byField := make(map[int][]fkPending)
+	var fields []int
+	for _, p := range sp.fkPending {
+		if byField[p.fldIx] == nil {
+			fields = append(fields, p.fldIx)
+		}
+		byField[p.fldIx] = append(byField[p.fldIx], p)
+	}
+	for _, fldIx := range fields {
+		fp := &sp.plan.Fields[fldIx]
+		fk := fp.Tag.ForeignKey
+		namer := sp.params.DB.NamingStrategy
+		table := namer.TableName(fk.Model)
+		column := namer.ColumnName(table, fk.Column)
+		keyColumn := namer.ColumnName(table, fk.Key)
+		columns, err := tableColumns(sp.params.DB, table)
+		if err != nil {
+			return fmt.Errorf("field: %v. %w", fp.Name, err)
+		}
+		// soft deleted parents, eg. of models embedding gorm.Model, are not matched
+		_, softDelete := find(columns, "deleted_at")
+
+		var texts []string
+		seen := make(map[string]bool)
+		for _, p := range byField[fldIx] {
+			if !seen[p.text] {
+				seen[p.text] = true
+				texts = append(texts, p.text)
+			}
+		}
+		keys, err := lookupKeys(sp.params.DB, table, column, keyColumn, texts, softDelete)
+		if err != nil {
+			return fmt.Errorf("field: %v. %w", fp.Name, err)
+		}
+		if fk.Create {
+			var missing []string
+			for _, text := range texts {
+				if _, ok := keys[text]; !ok {
+					missing = append(missing, text)
+				}
+			}
+			if len(missing) > 0 {
+				if err := createParents(sp.params.DB, table, column, missing, columns); err != nil {
+					return fmt.Errorf("field: %v. %w", fp.Name, err)
+				}
+				created, err := lookupKeys(sp.params.DB, table, column, keyColumn, missing, softDelete)
+				if err != nil {
+					return fmt.Errorf("field: %v. %w", fp.Name, err)
+				}
+				for _, text := range missing {
+					if key, ok := created[text]; ok {
+						keys[text] = key
+						sp.createdParents = append(sp.createdParents, createdRow{table: table, key: map[string]interface{}{keyColumn: key}})
+					}
+				}
+			}
+		}
+
+		for _, p := range byField[fldIx] {
+			key, ok := keys[p.text]
+			if !ok {
+				message := p.text + " is not in " + table + "." + column
+				if sp.params.OnUnmapped == UnmappedFail {
+					return fmt.Errorf("sheet: %v row: %v field: %v. %v", sp.sheetName, p.row+1, fp.Name, message)
+				}
+				sp.params.Report.Add(Issue{Sheet: sp.sheetName, Row: p.row + 1, Cell: xlsx.GetCellIDStringFromCoords(p.col, p.row), Field: fp.Name, Code: IssueUnmapped, Value: p.text, Message: message})
+				continue
+			}
+			value, err := fp.convert(csvCellValue(fmt.Sprint(key)), fp.Type, sp.params)
+			if err != nil {
+				return fmt.Errorf("sheet: %v row: %v field: %v. could not store key of %v. %w", sp.sheetName, p.row+1, fp.Name, p.text, err)
+			}
+			fieldByIndex(records.Index(p.record), fp.Index).Set(value)
+		}
+	}
+	return nil
+}
+
+// the keys of the rows of a table whose column holds each text.  Text is matched as the database compares
+// it: exactly, or ignoring case where the collation of the column does, eg. by default in mysql
+func lookupKeys(db *gorm.DB, table string, column string, keyColumn string, texts []string, softDelete bool) (map[string]interface{}, error) {
+	keys := make(map[string]interface{}, len(texts))
+	for start := 0; start < len(texts); start += fkBatchSize {
+		end := start + fkBatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		var rows []map[string]interface{}
+		query := db.Table(table).Select([]string{column, keyColumn}).Where(map[string]interface{}{column: texts[start:end]})
+		if softDelete {
+			query = query.Where("deleted_at IS NULL")
+		}
+		err := query.Find(&rows).Error
+		if err != nil {
+			return keys, fmt.Errorf("could not look up %v.%v: %w", table, column, err)
+		}
+		found := make(map[string]interface{}, len(rows))
+		for _, row := range rows {
+			found[fmt.Sprint(row[column])] = row[keyColumn]
+		}
+		for _, text := range texts[start:end] {
+			if key, ok := found[text]; ok {
+				keys[text] = key
+				continue
+			}
+			// rows the database matched though they differ in case
+			for _, row := range rows {
+				if strings.EqualFold(fmt.Sprint(row[column]), text) {
+					keys[text] = row[keyColumn]
+					break
+				}
+			}
+		}
+	}
+	return keys, nil
+}
+
+// inserts rows into a parent table holding just the matched column, and timestamps if the table has them
+func createParents(db *gorm.DB, table string, column string, texts []string, columns []string) error {
+	now := time.Now()
+	var stamps []string
+	for _, stamp := range []string{"created_at", "updated_at"} {
+		if _, ok := find(columns, stamp); ok {
+			stamps = append(stamps, stamp)
+		}
+	}
+	rows := make([]map[string]interface{}, len(texts))
+	for i, text := range texts {
+		rows[i] = map[string]interface{}{column: text}
+		for _, stamp := range stamps {
+			rows[i][stamp] = now
+		}
+	}
+	if err := db.Table(table).CreateInBatches(rows, fkBatchSize).Error; err != nil {
+		return fmt.Errorf("could not create rows of %v: %w", table, err)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package excel_to_gorm
+
+import (
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type Country struct {
+	ID   uint
+	Name string `gorm:"uniqueIndex"`
+	Code string
+}
+
+type fkApple struct {
+	ID        uint
+	Name      string `xtg:"col:Name"`
+	CountryID uint   `xtg:"col:Country,fk:Country.Name"`
+	RunID     uint   `xtg:"meta:run"`
+}
+
+type fkCreateApple struct {
+	ID        uint
+	Name      string `xtg:"col:Name"`
+	CountryID uint   `xtg:"col:Country,fk:Country.Name->ID;create"`
+	RunID     uint   `xtg:"meta:run"`
+}
+
+func countryBook(t *testing.T) []byte {
+	return mkBytes(t, map[string][][]interface{}{"S": {{"Name", "Country"}, {"Gala", "France"}, {"Fuji", "Japan"}, {"Braeburn", "France"}, {"Jazz", ""}}})
+}
+
+func TestForeignKeys(t *testing.T) {
+	db := openTestDB(t, &Country{})
+	db.Create(&[]Country{{Name: "France", Code: "FR"}, {Name: "Japan", Code: "JP"}})
+	wb := mkBook(t, map[string][][]interface{}{"S": {{"Name", "Country"}, {"Gala", "France"}, {"Fuji", "Japan"}, {"Jazz", ""}}})
+	out, err := WorksheetToSlice(wb.Sheet["S"], &fkApple{}, Params{DB: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]fkApple)
+	if got[0].CountryID != 1 || got[1].CountryID != 2 || got[2].CountryID != 0 {
+		t.Errorf("got %+v", got)
+	}
+
+	type byCode struct {
+		Code string `xtg:"col:Country,fk:Country.Name->Code"`
+	}
+	out, err = WorksheetToSlice(wb.Sheet["S"], &byCode{}, Params{DB: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.([]byCode); got[0].Code != "FR" || got[1].Code != "JP" {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestMissingParents(t *testing.T) {
+	db := openTestDB(t, &Country{})
+	db.Create(&Country{Name: "France"})
+	wb := mkBook(t, map[string][][]interface{}{"S": {{"Name", "Country"}, {"Gala", "France"}, {"Fuji", "Japan"}}})
+	if _, err := WorksheetToSlice(wb.Sheet["S"], &fkApple{}, Params{DB: db}); err == nil {
+		t.Error("expected an error for a missing parent with UnmappedFail")
+	}
+	report := &Report{}
+	out, err := WorksheetToSlice(wb.Sheet["S"], &fkApple{}, Params{DB: db, OnUnmapped: UnmappedEmpty, Report: report})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.([]fkApple); got[1].CountryID != 0 {
+		t.Errorf("got %+v", got)
+	}
+	if issues := report.Issues(); len(issues) != 1 || issues[0].Code != IssueUnmapped || issues[0].Cell != "B3" {
+		t.Errorf("got issues %+v", issues)
+	}
+}
+
+// the collation of the column decides whether case is ignored
+type NocaseCountry struct {
+	ID   uint
+	Name string `gorm:"type:text collate nocase"`
+}
+
+func TestForeignKeyCase(t *testing.T) {
+	db := openTestDB(t, &Country{}, &NocaseCountry{})
+	db.Create(&Country{Name: "France"})
+	db.Create(&[]NocaseCountry{{Name: "France"}, {Name: "FRANCE"}})
+	wb := mkBook(t, map[string][][]interface{}{"S": {{"Country"}, {"france"}, {"FRANCE"}}})
+
+	type caseSensitive struct {
+		CountryID uint `xtg:"col:Country,fk:Country.Name"`
+	}
+	if out, err := WorksheetToSlice(wb.Sheet["S"], &caseSensitive{}, Params{DB: db}); err == nil {
+		t.Errorf("got %+v, expected france not to match France", out)
+	}
+
+	type caseInsensitive struct {
+		CountryID uint `xtg:"col:Country,fk:NocaseCountry.Name"`
+	}
+	out, err := WorksheetToSlice(wb.Sheet["S"], &caseInsensitive{}, Params{DB: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the row which is the same is preferred
+	if got := out.([]caseInsensitive); got[0].CountryID != 1 || got[1].CountryID != 2 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestCreateParentsAndUndo(t *testing.T) {
+	db := openTestDB(t, &Country{}, &fkCreateApple{})
+	db.Create(&Country{Name: "France"})
+	run, err := ImportBytes(db, "apples.xlsx", countryBook(t), "S", &fkCreateApple{}, Params{}, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var japan Country
+	if err := db.Where("name = ?", "Japan").First(&japan).Error; err != nil {
+		t.Fatal(err)
+	}
+	var fuji fkCreateApple
+	db.Where("name = ?", "Fuji").First(&fuji)
+	if fuji.CountryID != japan.ID {
+		t.Errorf("got %+v, expected the country of Japan %v", fuji, japan.ID)
+	}
+
+	if err := UndoImport(db, run.ID); err != nil {
+		t.Fatal(err)
+	}
+	var countries []Country
+	db.Find(&countries)
+	if len(countries) != 1 || countries[0].Name != "France" {
+		t.Errorf("got %+v, expected the created country to be deleted", countries)
+	}
+	var count int64
+	db.Model(&fkCreateApple{}).Count(&count)
+	if count != 0 {
+		t.Errorf("got %v apples", count)
+	}
+}
+
+// the transaction of the import is used in place of a DB given in the params, so the parents it creates
+// are rolled back with the records
+func TestCreateParentsWithParamsDB(t *testing.T) {
+	db := openTestDB(t, &Country{}, &fkCreateApple{})
+	db.Create(&Country{Name: "France"})
+	run, err := ImportBytes(db, "apples.xlsx", countryBook(t), "S", &fkCreateApple{}, Params{DB: db}, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&Country{}).Count(&count)
+	if count != 2 {
+		t.Errorf("got %v countries, expected Japan to be created", count)
+	}
+	if err := UndoImport(db, run.ID); err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&Country{}).Count(&count)
+	if count != 1 {
+		t.Errorf("got %v countries after undo", count)
+	}
+}
+
+// parents with a deleted_at column are soft deleted, and created again by a later import
+type Region struct {
+	gorm.Model
+	Name string
+}
+
+type kentApple struct {
+	ID       uint
+	Name     string `xtg:"col:Name"`
+	RegionID uint   `xtg:"col:Region,fk:Region.Name;create"`
+	RunID    uint   `xtg:"meta:run"`
+}
+
+func TestSoftDeletedParents(t *testing.T) {
+	db := openTestDB(t, &Region{}, &kentApple{})
+	data := mkBytes(t, map[string][][]interface{}{"S": {{"Name", "Region"}, {"Gala", "Kent"}}})
+	run, err := ImportBytes(db, "apples.xlsx", data, "S", &kentApple{}, Params{}, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UndoImport(db, run.ID); err != nil {
+		t.Fatal(err)
+	}
+	var regions []Region
+	db.Unscoped().Find(&regions)
+	if len(regions) != 1 || !regions[0].DeletedAt.Valid {
+		t.Errorf("got %+v, expected Kent to be soft deleted", regions)
+	}
+
+	if _, err := ImportBytes(db, "apples.xlsx", data, "S", &kentApple{}, Params{}, ImportOptions{OnDuplicate: AllowDuplicate}); err != nil {
+		t.Fatal(err)
+	}
+	var apple kentApple
+	db.First(&apple)
+	if apple.RegionID == regions[0].ID {
+		t.Errorf("got %+v, expected a new region rather than the soft deleted one", apple)
+	}
+}
+
+func TestParseForeignKey(t *testing.T) {
+	fk, err := parseForeignKey("Country.Name->Code;create")
+	if err != nil || *fk != (ForeignKey{Model: "Country", Column: "Name", Key: "Code", Create: true}) {
+		t.Errorf("got %+v, %v", fk, err)
+	}
+	for _, param := range []string{"Country", ".Name", "Country.", "Country.Name->", "Country.Name;make"} {
+		if fk, err := parseForeignKey(param); err == nil {
+			t.Errorf("parseForeignKey(%q) got %+v, expected an error", param, fk)
+		}
+	}
+}
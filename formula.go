@@ -0,0 +1,708 @@
+package excel_to_gorm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/tealeg/xlsx/v3"
+)
+
+// Evaluator for the simple formulas written by scripts which generate workbooks without calculating them:
+// arithmetic, comparisons, references to cells and ranges of the same sheet, and a few functions.
+// Used when Params.EvaluateFormulas is set and a formula cell has no cached value
+
+// the error values of excel, which may be written in formulas
+var formulaErrors = []string{"#NULL!", "#DIV/0!", "#VALUE!", "#REF!", "#NAME?", "#NUM!", "#N/A", "#GETTING_DATA"}
+
+// the value of a formula or part of one
+type formulaValue struct {
+	kind CellKind // CellNumber, CellString, CellBool or CellError.  CellEmpty for empty cells
+	num  float64
+	str  string // text, or the code of an error such as #DIV/0!
+	rng  []formulaValue
+	// whether rng holds the cells of a range, rather than being a single value
+	isRange bool
+}
+
+func numberValue(f float64) formulaValue {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return errorValue("#NUM!")
+	}
+	return formulaValue{kind: CellNumber, num: f}
+}
+
+func errorValue(code string) formulaValue {
+	return formulaValue{kind: CellError, str: code}
+}
+
+// the value of a cell as used by a formula
+func cellFormulaValue(cv CellValue) formulaValue {
+	switch cv.Kind {
+	case CellEmpty:
+		return formulaValue{kind: CellEmpty}
+	case CellNumber, CellDate:
+		f, err := cv.Float()
+		if err != nil {
+			return errorValue("#VALUE!")
+		}
+		return formulaValue{kind: CellNumber, num: f}
+	case CellBool:
+		return formulaValue{kind: CellBool, num: boolNum(cv.Value == "1")}
+	case CellError:
+		return errorValue(cv.Value)
+	}
+	return formulaValue{kind: CellString, str: cv.Value}
+}
+
+// the result of a formula as a cell
+func (v formulaValue) cell() CellValue {
+	switch v.kind {
+	case CellNumber:
+		s := strconv.FormatFloat(v.num, 'f', -1, 64)
+		return CellValue{Kind: CellNumber, Value: s, Formatted: s}
+	case CellBool:
+		if v.num != 0 {
+			return CellValue{Kind: CellBool, Value: "1", Formatted: "TRUE"}
+		}
+		return CellValue{Kind: CellBool, Value: "0", Formatted: "FALSE"}
+	case CellString, CellError:
+		if v.str == "" {
+			return CellValue{}
+		}
+		return CellValue{Kind: v.kind, Value: v.str, Formatted: v.str}
+	}
+	return CellValue{}
+}
+
+func boolNum(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// a value as a number, as excel converts them for arithmetic
+func (v formulaValue) number() (float64, *formulaValue) {
+	switch v.kind {
+	case CellNumber, CellBool:
+		return v.num, nil
+	case CellEmpty:
+		return 0, nil
+	case CellString:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v.str), 64)
+		if err != nil {
+			e := errorValue("#VALUE!")
+			return 0, &e
+		}
+		return f, nil
+	}
+	return 0, &v
+}
+
+// a value as text, for & and comparisons
+func (v formulaValue) text() string {
+	switch v.kind {
+	case CellNumber:
+		return strconv.FormatFloat(v.num, 'f', -1, 64)
+	case CellBool:
+		if v.num != 0 {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+	return v.str
+}
+
+// looks up the cells referred to by a formula
+type formulaEnv interface {
+	// the value of the cell at a 0 based row and column
+	cellValue(row, col int) (formulaValue, error)
+	// the value of a name such as a heading.  found is false if the name means nothing
+	namedValue(name string) (v formulaValue, found bool, err error)
+}
+
+// implemented by envs which only read cells through absolute references such as $B$3, as for expr:, so a
+// name which is not a heading is an error rather than taken for a cell, eg. Q1 or ABC123
+type absoluteRefsEnv interface {
+	absoluteRefsOnly()
+}
+
+// parses and evaluates a formula, with or without its leading =
+type formulaParser struct {
+	src          string
+	pos          int
+	env          formulaEnv
+	absoluteRefs bool
+}
+
+func evaluateFormula(formula string, env formulaEnv) (formulaValue, error) {
+	p := &formulaParser{src: strings.TrimPrefix(strings.TrimSpace(formula), "="), env: env}
+	_, p.absoluteRefs = env.(absoluteRefsEnv)
+	v, err := p.comparison()
+	if err != nil {
+		return v, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return v, fmt.Errorf("unexpected %q in formula %v", p.src[p.pos:], formula)
+	}
+	if v.isRange {
+		return errorValue("#VALUE!"), nil
+	}
+	return v, nil
+}
+
+func (p *formulaParser) skipSpace() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// consumes op if it is next
+func (p *formulaParser) accept(op string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.src[p.pos:], op) {
+		p.pos += len(op)
+		return true
+	}
+	return false
+}
+
+func (p *formulaParser) comparison() (formulaValue, error) {
+	left, err := p.concat()
+	if err != nil {
+		return left, err
+	}
+	for {
+		var op string
+		for _, candidate := range []string{"<>", "<=", ">=", "=", "<", ">"} {
+			if p.accept(candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		right, err := p.concat()
+		if err != nil {
+			return right, err
+		}
+		left = compareValues(left, right, op)
+	}
+}
+
+func compareValues(left, right formulaValue, op string) formulaValue {
+	if left.kind == CellError {
+		return left
+	}
+	if right.kind == CellError {
+		return right
+	}
+	var cmp int
+	ln, lerr := left.number()
+	rn, rerr := right.number()
+	if lerr == nil && rerr == nil && left.kind != CellString && right.kind != CellString {
+		switch {
+		case ln < rn:
+			cmp = -1
+		case ln > rn:
+			cmp = 1
+		}
+	} else {
+		cmp = strings.Compare(strings.ToLower(left.text()), strings.ToLower(right.text()))
+	}
+	var result bool
+	switch op {
+	case "=":
+		result = cmp == 0
+	case "<>":
+		result = cmp != 0
+	case "<":
+		result = cmp < 0
+	case "<=":
+		result = cmp <= 0
+	case ">":
+		result = cmp > 0
+	case ">=":
+		result = cmp >= 0
+	}
+	return formulaValue{kind: CellBool, num: boolNum(result)}
+}
+
+func (p *formulaParser) concat() (formulaValue, error) {
+	left, err := p.additive()
+	if err != nil {
+		return left, err
+	}
+	for p.accept("&") {
+		right, err := p.additive()
+		if err != nil {
+			return right, err
+		}
+		switch {
+		case left.kind == CellError:
+		case right.kind == CellError:
+			left = right
+		default:
+			left = formulaValue{kind: CellString, str: left.text() + right.text()}
+		}
+	}
+	return left, nil
+}
+
+func (p *formulaParser) additive() (formulaValue, error) {
+	left, err := p.multiplicative()
+	if err != nil {
+		return left, err
+	}
+	for {
+		var op byte
+		switch {
+		case p.accept("+"):
+			op = '+'
+		case p.accept("-"):
+			op = '-'
+		default:
+			return left, nil
+		}
+		right, err := p.multiplicative()
+		if err != nil {
+			return right, err
+		}
+		left = arithmetic(left, right, op)
+	}
+}
+
+func (p *formulaParser) multiplicative() (formulaValue, error) {
+	left, err := p.power()
+	if err != nil {
+		return left, err
+	}
+	for {
+		var op byte
+		switch {
+		case p.accept("*"):
+			op = '*'
+		case p.accept("/"):
+			op = '/'
+		default:
+			return left, nil
+		}
+		right, err := p.power()
+		if err != nil {
+			return right, err
+		}
+		left = arithmetic(left, right, op)
+	}
+}
+
+func (p *formulaParser) power() (formulaValue, error) {
+	left, err := p.unary()
+	if err != nil {
+		return left, err
+	}
+	for p.accept("^") {
+		right, err := p.unary()
+		if err != nil {
+			return right, err
+		}
+		left = arithmetic(left, right, '^')
+	}
+	return left, nil
+}
+
+// as in excel, -2^2 is 4
+func (p *formulaParser) unary() (formulaValue, error) {
+	switch {
+	case p.accept("-"):
+		v, err := p.unary()
+		if err != nil {
+			return v, err
+		}
+		return arithmetic(numberValue(0), v, '-'), nil
+	case p.accept("+"):
+		return p.unary()
+	}
+	v, err := p.primary()
+	if err != nil {
+		return v, err
+	}
+	for p.accept("%") {
+		v = arithmetic(v, numberValue(100), '/')
+	}
+	return v, nil
+}
+
+func arithmetic(left, right formulaValue, op byte) formulaValue {
+	if left.isRange || right.isRange {
+		return errorValue("#VALUE!")
+	}
+	l, errVal := left.number()
+	if errVal != nil {
+		return *errVal
+	}
+	r, errVal := right.number()
+	if errVal != nil {
+		return *errVal
+	}
+	switch op {
+	case '+':
+		return numberValue(l + r)
+	case '-':
+		return numberValue(l - r)
+	case '*':
+		return numberValue(l * r)
+	case '/':
+		if r == 0 {
+			return errorValue("#DIV/0!")
+		}
+		return numberValue(l / r)
+	}
+	return numberValue(math.Pow(l, r))
+}
+
+func (p *formulaParser) primary() (formulaValue, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return formulaValue{}, errors.New("formula ends unexpectedly")
+	}
+	ch := p.src[p.pos]
+	switch {
+	case ch == '(':
+		p.pos++
+		v, err := p.comparison()
+		if err != nil {
+			return v, err
+		}
+		if !p.accept(")") {
+			return v, errors.New("missing ) in formula")
+		}
+		return v, nil
+	case ch == '"':
+		return p.stringLiteral()
+	case ch == '[':
+		// a name which may hold spaces, eg. [First Name]
+		end := strings.IndexByte(p.src[p.pos:], ']')
+		if end < 0 {
+			return formulaValue{}, errors.New("missing ] in formula")
+		}
+		name := p.src[p.pos+1 : p.pos+end]
+		p.pos += end + 1
+		v, found, err := p.env.namedValue(name)
+		if err == nil && !found {
+			err = errors.New("unknown name [" + name + "] in formula")
+		}
+		return v, err
+	case ch == '#':
+		// an error literal such as #N/A
+		for _, code := range formulaErrors {
+			if strings.HasPrefix(strings.ToUpper(p.src[p.pos:]), code) {
+				p.pos += len(code)
+				return errorValue(code), nil
+			}
+		}
+		return formulaValue{}, fmt.Errorf("unexpected %q in formula", p.src[p.pos:])
+	case ch >= '0' && ch <= '9' || ch == '.':
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		// exponent, eg. 1E-3
+		if p.pos < len(p.src) && (p.src[p.pos] == 'E' || p.src[p.pos] == 'e') {
+			end := p.pos + 1
+			if end < len(p.src) && (p.src[end] == '+' || p.src[end] == '-') {
+				end++
+			}
+			if end < len(p.src) && p.src[end] >= '0' && p.src[end] <= '9' {
+				for p.pos = end; p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9'; p.pos++ {
+				}
+			}
+		}
+		f, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return formulaValue{}, fmt.Errorf("invalid number %v in formula", p.src[start:p.pos])
+		}
+		return numberValue(f), nil
+	case ch == '$' || unicode.IsLetter(rune(ch)):
+		return p.name()
+	}
+	return formulaValue{}, fmt.Errorf("unexpected %q in formula", p.src[p.pos:])
+}
+
+func (p *formulaParser) stringLiteral() (formulaValue, error) {
+	var sb strings.Builder
+	p.pos++
+	for p.pos < len(p.src) {
+		ch := p.src[p.pos]
+		p.pos++
+		if ch != '"' {
+			sb.WriteByte(ch)
+			continue
+		}
+		// "" is a quote
+		if p.pos < len(p.src) && p.src[p.pos] == '"' {
+			sb.WriteByte('"')
+			p.pos++
+			continue
+		}
+		return formulaValue{kind: CellString, str: sb.String()}, nil
+	}
+	return formulaValue{}, errors.New("string is not closed in formula")
+}
+
+// a function call, a name, a cell reference or range, or TRUE or FALSE
+func (p *formulaParser) name() (formulaValue, error) {
+	start := p.pos
+	for p.pos < len(p.src) && (p.src[p.pos] == '$' || p.src[p.pos] == '.' || p.src[p.pos] == '_' ||
+		unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos]))) {
+		p.pos++
+	}
+	raw := p.src[start:p.pos]
+	name := strings.ToUpper(raw)
+	if p.pos < len(p.src) && p.src[p.pos] == '!' {
+		return formulaValue{}, errors.New("references to other sheets are not supported")
+	}
+	if p.accept("(") {
+		return p.call(name)
+	}
+	switch name {
+	case "TRUE":
+		return formulaValue{kind: CellBool, num: 1}, nil
+	case "FALSE":
+		return formulaValue{kind: CellBool}, nil
+	}
+	if v, found, err := p.env.namedValue(raw); found || err != nil {
+		return v, err
+	}
+	row, col, err := parseCellRef(name)
+	if err != nil {
+		return formulaValue{}, errors.New("unsupported name " + raw + " in formula")
+	}
+	if err := p.checkAbsolute(raw); err != nil {
+		return formulaValue{}, err
+	}
+	if !p.accept(":") {
+		return p.env.cellValue(row, col)
+	}
+	p.skipSpace()
+	start = p.pos
+	for p.pos < len(p.src) && (p.src[p.pos] == '$' || unicode.IsLetter(rune(p.src[p.pos])) || unicode.IsDigit(rune(p.src[p.pos]))) {
+		p.pos++
+	}
+	lastRow, lastCol, err := parseCellRef(strings.ToUpper(p.src[start:p.pos]))
+	if err != nil {
+		return formulaValue{}, err
+	}
+	if err := p.checkAbsolute(p.src[start:p.pos]); err != nil {
+		return formulaValue{}, err
+	}
+	if lastRow < row {
+		row, lastRow = lastRow, row
+	}
+	if lastCol < col {
+		col, lastCol = lastCol, col
+	}
+	if (lastRow-row+1)*(lastCol-col+1) > 1000000 {
+		return formulaValue{}, errors.New("range in formula is too large")
+	}
+	rng := formulaValue{isRange: true}
+	for r := row; r <= lastRow; r++ {
+		for c := col; c <= lastCol; c++ {
+			v, err := p.env.cellValue(r, c)
+			if err != nil {
+				return v, err
+			}
+			rng.rng = append(rng.rng, v)
+		}
+	}
+	return rng, nil
+}
+
+// checks a cell reference is absolute, eg. $B$3, if the env only reads cells through those
+func (p *formulaParser) checkAbsolute(ref string) error {
+	if !p.absoluteRefs || (strings.HasPrefix(ref, "$") && strings.Count(ref, "$") == 2) {
+		return nil
+	}
+	return errors.New("unknown name " + ref + " in formula. cells other than the headings of the row are written as absolute references, eg. $B$3")
+}
+
+// parses a reference such as B3 or $B$3 into a 0 based row and column
+func parseCellRef(ref string) (int, int, error) {
+	clean := strings.Replace(ref, "$", "", -1)
+	i := 0
+	for i < len(clean) && clean[i] >= 'A' && clean[i] <= 'Z' {
+		i++
+	}
+	if i == 0 || i == len(clean) {
+		return 0, 0, errors.New("unsupported name " + ref + " in formula")
+	}
+	col, err := colLettersToNumber(clean[:i])
+	if err != nil {
+		return 0, 0, err
+	}
+	row, err := strconv.Atoi(clean[i:])
+	if err != nil || row < 1 {
+		return 0, 0, errors.New("unsupported name " + ref + " in formula")
+	}
+	return row - 1, col - 1, nil
+}
+
+// the arguments of a function, up to and including the closing bracket
+func (p *formulaParser) args() ([]formulaValue, error) {
+	var args []formulaValue
+	if p.accept(")") {
+		return args, nil
+	}
+	for {
+		v, err := p.comparison()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+		if p.accept(")") {
+			return args, nil
+		}
+		if !p.accept(",") {
+			return nil, errors.New("missing ) in formula")
+		}
+	}
+}
+
+func (p *formulaParser) call(name string) (formulaValue, error) {
+	args, err := p.args()
+	if err != nil {
+		return formulaValue{}, err
+	}
+	switch name {
+	case "SUM", "MIN", "MAX", "AVERAGE", "COUNT":
+		return aggregate(name, args), nil
+	case "ABS":
+		if len(args) != 1 {
+			return formulaValue{}, errors.New("ABS takes one argument")
+		}
+		f, errVal := args[0].number()
+		if errVal != nil {
+			return *errVal, nil
+		}
+		return numberValue(math.Abs(f)), nil
+	case "ROUND":
+		if len(args) != 2 {
+			return formulaValue{}, errors.New("ROUND takes two arguments")
+		}
+		f, errVal := args[0].number()
+		if errVal != nil {
+			return *errVal, nil
+		}
+		digits, errVal := args[1].number()
+		if errVal != nil {
+			return *errVal, nil
+		}
+		scale := math.Pow(10, math.Trunc(digits))
+		return numberValue(math.Round(f*scale) / scale), nil
+	case "IF":
+		if len(args) < 2 || len(args) > 3 {
+			return formulaValue{}, errors.New("IF takes two or three arguments")
+		}
+		cond, errVal := args[0].number()
+		if errVal != nil {
+			return *errVal, nil
+		}
+		if cond != 0 {
+			return args[1], nil
+		}
+		if len(args) == 3 {
+			return args[2], nil
+		}
+		return formulaValue{kind: CellBool}, nil
+	}
+	return formulaValue{}, errors.New("unsupported function " + name + " in formula")
+}
+
+// SUM and friends.  As in excel, text and empty cells in ranges are skipped
+func aggregate(name string, args []formulaValue) formulaValue {
+	var values []float64
+	for _, arg := range args {
+		cells := []formulaValue{arg}
+		if arg.isRange {
+			cells = arg.rng
+		}
+		for _, v := range cells {
+			switch {
+			case v.kind == CellError:
+				return v
+			case v.kind == CellNumber, !arg.isRange && v.kind == CellBool:
+				values = append(values, v.num)
+			case !arg.isRange && v.kind == CellString:
+				f, errVal := v.number()
+				if errVal != nil {
+					return *errVal
+				}
+				values = append(values, f)
+			}
+		}
+	}
+	if name == "COUNT" {
+		return numberValue(float64(len(values)))
+	}
+	if len(values) == 0 {
+		if name == "AVERAGE" {
+			return errorValue("#DIV/0!")
+		}
+		return numberValue(0)
+	}
+	min, max, sum := values[0], values[0], 0.0
+	for _, f := range values {
+		sum += f
+		min = math.Min(min, f)
+		max = math.Max(max, f)
+	}
+	switch name {
+	case "MIN":
+		return numberValue(min)
+	case "MAX":
+		return numberValue(max)
+	case "AVERAGE":
+		return numberValue(sum / float64(len(values)))
+	}
+	return numberValue(sum)
+}
+
+// evaluates the formulas of a sheet's cells, following references to other formula cells
+type sheetFormulaEnv struct {
+	src      SheetSource
+	visiting map[[2]int]bool
+}
+
+func (env *sheetFormulaEnv) cellValue(row, col int) (formulaValue, error) {
+	cv := env.src.Cell(row, col)
+	if cv.Kind != CellEmpty || cv.Formula == "" {
+		return cellFormulaValue(cv), nil
+	}
+	key := [2]int{row, col}
+	if env.visiting[key] {
+		return formulaValue{}, errors.New("circular reference to " + xlsx.GetCellIDStringFromCoords(col, row))
+	}
+	env.visiting[key] = true
+	defer delete(env.visiting, key)
+	return evaluateFormula(cv.Formula, env)
+}
+
+// formulas of cells have no names
+func (env *sheetFormulaEnv) namedValue(name string) (formulaValue, bool, error) {
+	return formulaValue{}, false, nil
+}
+
+// evaluates the formula of a cell without a cached value
+func evaluateCell(src SheetSource, row, col int) (CellValue, error) {
+	env := &sheetFormulaEnv{src: src, visiting: make(map[[2]int]bool)}
+	v, err := env.cellValue(row, col)
+	if err != nil {
+		return CellValue{}, err
+	}
+	return v.cell(), nil
+}
@@ -0,0 +1,112 @@
+package excel_to_gorm
+
+import "testing"
+
+func TestEvaluateFormula(t *testing.T) {
+	src := NewGridSource("s", [][]string{
+		{"2", "3", "Gala", ""},
+		{"4", "x", "1", "TRUE"},
+	})
+	tests := []struct {
+		formula string
+		want    string
+	}{
+		{"=1+2*3", "7"},
+		{"(1+2)*3", "9"},
+		{"2^3-1", "7"},
+		{"-A1+B1", "1"},
+		{"A1*B1%", "0.06"},
+		{"SUM(A1:B2)", "9"},
+		{"SUM(A1:B1,10)", "15"},
+		{"MIN(A1:A2)", "2"},
+		{"MAX(A1:A2)", "4"},
+		{"AVERAGE(A1:A2)", "3"},
+		{"COUNT(A1:D2)", "4"},
+		{"ROUND(10/3,2)", "3.33"},
+		{"ABS(-$A$1)", "2"},
+		{`IF(A2>A1,"more","less")`, "more"},
+		{`C1&"-"&A1`, "Gala-2"},
+		{`"a""b"`, `a"b`},
+		{"A1=2", "1"},
+		{"A1<>2", "0"},
+		{"D1+1", "1"},
+		{"1/0", "#DIV/0!"},
+		{"B2+1", "#VALUE!"},
+		{"#N/A", "#N/A"},
+	}
+	for _, tt := range tests {
+		v, err := evaluateFormula(tt.formula, &sheetFormulaEnv{src: src, visiting: make(map[[2]int]bool)})
+		if err != nil {
+			t.Errorf("%v: %v", tt.formula, err)
+			continue
+		}
+		if got := v.cell().Value; got != tt.want {
+			t.Errorf("%v = %q, want %q", tt.formula, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateFormulaErrors(t *testing.T) {
+	src := NewGridSource("s", [][]string{{"1"}})
+	for _, formula := range []string{"VLOOKUP(1,A1:B2,2)", "1+", "(1", "SUM(A1:)", `"open`, "Apples"} {
+		if _, err := evaluateFormula(formula, &sheetFormulaEnv{src: src, visiting: make(map[[2]int]bool)}); err == nil {
+			t.Errorf("%v: expected an error", formula)
+		}
+	}
+}
+
+type formulaRow struct {
+	Total   float64 `xtg:"col:Total"`
+	Name    string  `xtg:"col:Name"`
+	A       float64 `xtg:"col:A"`
+	B       int     `xtg:"col:B"`
+	Label   string  `xtg:"col:Label"`
+	Formula string  `xtg:"meta:formula"`
+}
+
+func formulaBook() map[string][][]interface{} {
+	return map[string][][]interface{}{"S": {
+		{"Total", "Name", "A", "B", "Label"},
+		{fml("C2+D2*2"), "x", 1.5, 2, fml(`B2&"-"&IF(C2>1,"big","small")`)},
+		{fml("SUM(C2:D3)"), "y", fml("ROUND(C2/3,2)"), 4, fml("E3")},
+	}}
+}
+
+func TestFormulasWithoutResults(t *testing.T) {
+	sh := mkBook(t, formulaBook()).Sheet["S"]
+
+	report := &Report{}
+	out, err := WorksheetToSlice(sh, &formulaRow{}, Params{Report: report})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]formulaRow)
+	if got[0].Label != "" || got[0].Formula != "C2+D2*2" {
+		t.Errorf("without evaluation got %+v", got[0])
+	}
+	if issues := report.Issues(); len(issues) != 5 || issues[0].Code != IssueUncachedFormula {
+		t.Errorf("without evaluation got issues %v", issues)
+	}
+
+	report = &Report{}
+	out, err = WorksheetToSlice(sh, &formulaRow{}, Params{EvaluateFormulas: true, Report: report})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = out.([]formulaRow)
+	if got[0].Total != 5.5 || got[0].Label != "x-big" || got[1].A != 0.5 || got[1].Total != 8 {
+		t.Errorf("with evaluation got %+v", got)
+	}
+	// E3 refers to itself
+	if issues := report.Issues(); len(issues) != 1 || issues[0].Code != IssueFormula || issues[0].Cell != "E3" {
+		t.Errorf("with evaluation got issues %v", issues)
+	}
+}
+
+// absolute references lose their $, which does not change the value of the formula
+func TestODSFormula(t *testing.T) {
+	got := odsFormula(`of:=SUM([.A1:.A3];[$Sheet2.B1])+[.$C$4]&"a;[.b]"`)
+	if want := `SUM(A1:A3,Sheet2!B1)+C4&"a;[.b]"`; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -7,7 +7,9 @@ require (
 	github.com/frankban/quicktest v1.11.2 // indirect
 	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
 	github.com/tealeg/xlsx/v3 v3.2.3
+	golang.org/x/text v0.3.3
 	gopkg.in/check.v1 v1.0.0-20200902074654-038fdea0a05b // indirect
 	gorm.io/driver/postgres v1.1.0
+	gorm.io/driver/sqlite v1.1.4
 	gorm.io/gorm v1.21.9
 )
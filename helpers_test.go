@@ -0,0 +1,130 @@
+package excel_to_gorm
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tealeg/xlsx/v3"
+)
+
+// builds an xlsx workbook of the given sheets and rows, written out and read back as if from a file.
+// The sheets are added in the order of their names
+func mkBook(t testing.TB, sheets map[string][][]interface{}) *xlsx.File {
+	f := xlsx.NewFile()
+	hasErrors := false
+	names := make([]string, 0, len(sheets))
+	for name := range sheets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rows := sheets[name]
+		sh, err := f.AddSheet(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows {
+			r := sh.AddRow()
+			for _, v := range row {
+				c := r.AddCell()
+				switch x := v.(type) {
+				case nil:
+				case string:
+					c.SetString(x)
+				case float64:
+					c.SetFloat(x)
+				case int:
+					c.SetInt(x)
+				case bool:
+					c.SetBool(x)
+				case time.Time:
+					c.SetDate(x)
+				case fml:
+					c.SetFormula(string(x))
+				case xerr:
+					c.SetNumeric(string(x))
+					hasErrors = true
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := f.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if hasErrors {
+		data = markErrorCells(t, data)
+	}
+	wb, err := xlsx.OpenBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wb
+}
+
+// numeric cells holding an error value, as written for xerr, become error cells as excel saves them
+var numericErrorCell = regexp.MustCompile(`<c r="([A-Z]+[0-9]+)"((?: s="[0-9]+")?)><v>(#[^<]*)</v>`)
+
+func markErrorCells(t testing.TB, data []byte) []byte {
+	return rewriteZip(t, data, func(name string, content []byte) []byte {
+		if !strings.HasPrefix(name, "xl/worksheets/") {
+			return content
+		}
+		return numericErrorCell.ReplaceAll(content, []byte(`<c r="$1"$2 t="e"><v>$3</v>`))
+	})
+}
+
+// rewrites each file of a zip archive, such as an xlsx file, through edit
+func rewriteZip(t testing.TB, data []byte, edit func(name string, content []byte) []byte) []byte {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, zf := range zr.File {
+		rc, err := zf.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := zw.Create(zf.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(edit(zf.Name, content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// the bytes of an xlsx file holding the given sheets and rows
+func mkBytes(t testing.TB, sheets map[string][][]interface{}) []byte {
+	wb := mkBook(t, sheets)
+	var buf bytes.Buffer
+	if err := wb.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// a formula cell for mkBook
+type fml string
+
+// a cell for mkBook holding an error value cached by excel, eg. #N/A
+type xerr string
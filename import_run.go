@@ -0,0 +1,678 @@
+package excel_to_gorm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ImportRun records an import of a sheet into the database by ImportSheet.
+// The tables need to be created by the caller, eg. db.AutoMigrate(&excel_to_gorm.ImportRun{}, &excel_to_gorm.ImportBeforeImage{})
+type ImportRun struct {
+	gorm.Model
+	FileName       string
+	FileHash       string `gorm:"size:64;index"` // hex encoded SHA-256 of the workbook
+	Sheet          string
+	ModelTable     string `gorm:"index"` // table of the imported model
+	RunColumn      string // column of the meta:run field of the model, if any.  Needed by UndoImport
+	Status         string // running, done, failed or undone
+	RowsRead       int    // rows of the sheet, excluding the heading row
+	RecordsCreated int    // records of the model inserted, and their children
+	RecordsUpdated int    // existing rows overwritten by an upsert
+	Errors         string
+	DuplicateOf    *uint  // earlier run of the same file into the same model, if any
+	Tags           string // json encoded ImportOptions.Tags
+	StartedAt      time.Time
+	FinishedAt     *time.Time
+	UndoneAt       *time.Time
+}
+
+// ImportBeforeImage holds a row as it was before ImportSheet upserted it, so UndoImport can restore it.
+// Only the columns the upsert overwrote are kept.  Rows the run created in other tables than that of the
+// model, such as the parents of fk: fields and the children of records, are recorded with no Data, so
+// UndoImport can delete them
+type ImportBeforeImage struct {
+	ID          uint `gorm:"primarykey"`
+	ImportRunID uint `gorm:"index"`
+	ModelTable  string
+	Created     string // ImportCreatedParent or ImportCreatedChild if the run created the row rather than updated it
+	PrimaryKey  []byte // gob encoded map of the columns identifying the row to values, usually its primary key
+	Data        []byte // gob encoded map of columns to values, as read from the database
+}
+
+// the values read from the database are those of database/sql/driver.Value, which gob knows apart from time
+func init() {
+	gob.Register(time.Time{})
+}
+
+const (
+	ImportRunning = "running"
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+	ImportUndone  = "undone"
+)
+
+// rows created by a run in other tables than that of the model, as recorded in ImportBeforeImage.Created
+const (
+	ImportCreatedParent = "parent" // created for a fk:<Model>.<Field>;create tag
+	ImportCreatedChild  = "child"  // held by a children field of a record
+)
+
+// a row created by a run in another table than that of the model, identified by its key columns
+type createdRow struct {
+	table string
+	key   map[string]interface{}
+}
+
+// what ImportSheet does when the file has already been imported into the model
+type DuplicatePolicy int
+
+const (
+	RefuseDuplicate DuplicatePolicy = iota // return ErrDuplicateImport without importing
+	WarnDuplicate                          // as AllowDuplicate, and add an IssueDuplicateImport to Params.Report
+	AllowDuplicate                         // import and record the earlier run in DuplicateOf
+)
+
+// returned by ImportSheet when the file has already been imported into the model
+var ErrDuplicateImport = errors.New("file has already been imported")
+
+type ImportOptions struct {
+	OnDuplicate DuplicatePolicy
+	Tags        map[string]string // stored with the run, eg. the user who uploaded the file
+	BatchSize   int               // records inserted per statement.  0 uses the gorm default
+	// columns (as named in the database) identifying existing rows to update rather than insert, eg. []string{"name"}.
+	// The columns need a unique index.  Only the columns the sheet fills, the update time and the deletion time
+	// of soft deleted rows are overwritten.
+	// The rows are saved as ImportBeforeImages first, so UndoImport can restore them
+	UpsertOn []string
+}
+
+// reads a sheet of an excel file into the table of model, recording the import as an ImportRun.
+// Records are created in a single transaction, so either all or none of them are imported.
+// Tag a uint field of the model xtg:"meta:run" to record the ID of the ImportRun on each record.
+// An earlier import of the same file into the same model is handled as opts.OnDuplicate says.
+// The run is returned even if the import fails, unless the run itself could not be recorded
+func ImportSheet(db *gorm.DB, fileName string, sheetName string, model interface{}, params Params, opts ImportOptions) (*ImportRun, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, errors.New("could not open file: " + fileName)
+	}
+	return ImportBytes(db, fileName, data, sheetName, model, params, opts)
+}
+
+// as ImportSheet, for a workbook held in memory, eg. an upload.  fileName is recorded in the run
+func ImportBytes(db *gorm.DB, fileName string, data []byte, sheetName string, model interface{}, params Params, opts ImportOptions) (*ImportRun, error) {
+	hash := sha256.Sum256(data)
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, fmt.Errorf("could not parse model: %w", err)
+	}
+
+	run := &ImportRun{
+		FileName:   fileName,
+		FileHash:   hex.EncodeToString(hash[:]),
+		Sheet:      sheetName,
+		ModelTable: stmt.Schema.Table,
+		RunColumn:  runColumn(stmt.Schema),
+		Status:     ImportRunning,
+		StartedAt:  time.Now(),
+	}
+	if len(opts.Tags) > 0 {
+		tags, err := json.Marshal(opts.Tags)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode import tags: %w", err)
+		}
+		run.Tags = string(tags)
+	}
+
+	if err := db.Create(run).Error; err != nil {
+		return nil, fmt.Errorf("could not record import run: %w", err)
+	}
+
+	err := importRecords(db, run, data, model, params, opts)
+	if errors.Is(err, ErrDuplicateImport) {
+		// a refused upload leaves no run behind
+		if delErr := db.Unscoped().Delete(run).Error; delErr != nil {
+			return nil, fmt.Errorf("could not remove refused import run: %w", delErr)
+		}
+		return nil, err
+	}
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.Status = ImportDone
+	if err != nil {
+		run.Status = ImportFailed
+		run.Errors = err.Error()
+	}
+	if saveErr := db.Save(run).Error; saveErr != nil && err == nil {
+		err = fmt.Errorf("could not record import run: %w", saveErr)
+	}
+	return run, err
+}
+
+// reads the sheet and creates its records, updating the counts of the run
+func importRecords(db *gorm.DB, run *ImportRun, data []byte, model interface{}, params Params, opts ImportOptions) error {
+	w, err := OpenReader(bytes.NewReader(data), int64(len(data)), run.FileName)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	run.RowsRead, _, err = w.Dimensions(run.Sheet)
+	if err != nil {
+		return err
+	}
+	if !params.FirstRowHasData && run.RowsRead > 0 {
+		run.RowsRead--
+	}
+
+	if params.FileName == "" {
+		params.FileName = run.FileName
+	}
+	params.ImportRunID = run.ID
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := checkDuplicate(tx, run, params, opts); err != nil {
+			return err
+		}
+		// parents of fk: fields are looked up and created in the transaction, so they are rolled back with the records
+		params.DB = tx
+		src, done, err := w.openSheet(run.Sheet)
+		if err != nil {
+			return err
+		}
+		defer done()
+		records, sp, err := sourceToSlice(src, model, params)
+		if err != nil {
+			return err
+		}
+		if sp != nil {
+			if err := saveCreatedRows(tx, run, ImportCreatedParent, sp.createdParents); err != nil {
+				return err
+			}
+		}
+		// gorm needs a pointer to the slice to fill in the IDs of the new records
+		recordsPtr := reflect.New(reflect.TypeOf(records))
+		recordsPtr.Elem().Set(reflect.ValueOf(records))
+		if recordsPtr.Elem().Len() == 0 {
+			return nil
+		}
+		stmt := &gorm.Statement{DB: tx}
+		if err := stmt.Parse(model); err != nil {
+			return fmt.Errorf("could not parse model: %w", err)
+		}
+		// records matching existing rows update them, or are left out if there is nothing to update
+		matched := 0
+		// the upsert clause only applies to the records, not the rows recorded for undo afterwards
+		create := tx
+		if len(opts.UpsertOn) > 0 {
+			updateCols := upsertColumns(stmt.Schema, sp, opts.UpsertOn)
+			matched, err = saveBeforeImages(tx, run, stmt.Schema, recordsPtr.Elem(), opts.UpsertOn, updateCols)
+			if err != nil {
+				return fmt.Errorf("could not create records: %w", err)
+			}
+			if len(updateCols) > 0 {
+				run.RecordsUpdated = matched
+			}
+			conflictCols := make([]clause.Column, len(opts.UpsertOn))
+			for i, col := range opts.UpsertOn {
+				conflictCols[i] = clause.Column{Name: col}
+			}
+			onConflict := clause.OnConflict{Columns: conflictCols, DoUpdates: clause.AssignmentColumns(updateCols)}
+			if len(updateCols) == 0 {
+				onConflict = clause.OnConflict{Columns: conflictCols, DoNothing: true}
+			}
+			create = tx.Clauses(onConflict)
+		}
+		if opts.BatchSize > 0 {
+			err = create.CreateInBatches(recordsPtr.Interface(), opts.BatchSize).Error
+		} else {
+			err = create.Create(recordsPtr.Interface()).Error
+		}
+		if err != nil {
+			return fmt.Errorf("could not create records: %w", err)
+		}
+		// the children of the records are created through the associations of their children fields
+		children := createdChildren(stmt.Schema, sp.plan, recordsPtr.Elem())
+		if err := saveCreatedRows(tx, run, ImportCreatedChild, children); err != nil {
+			return err
+		}
+		run.RecordsCreated = recordsPtr.Elem().Len() - matched + len(children)
+		return nil
+	})
+}
+
+// the children of the records held by their children fields, and their own children, once created through
+// the has-many associations of the fields
+func createdChildren(sch *schema.Schema, plan *ModelPlan, records reflect.Value) []createdRow {
+	var created []createdRow
+	for _, fldIx := range plan.Children {
+		fp := &plan.Fields[fldIx]
+		rel := sch.Relationships.Relations[fp.Name]
+		if rel == nil || rel.Type != schema.HasMany || len(rel.FieldSchema.PrimaryFields) == 0 {
+			continue
+		}
+		childPlan, err := planFor(childStructType(fp.Type))
+		if err != nil {
+			continue
+		}
+		for i := 0; i < records.Len(); i++ {
+			children := fieldByIndex(reflect.Indirect(records.Index(i)), fp.Index)
+			for j := 0; j < children.Len(); j++ {
+				child := reflect.Indirect(children.Index(j))
+				key := make(map[string]interface{}, len(rel.FieldSchema.PrimaryFields))
+				saved := true
+				for _, field := range rel.FieldSchema.PrimaryFields {
+					value, isZero := field.ValueOf(child)
+					key[field.DBName] = value
+					// children the database did not create, eg. on a conflict, have no key
+					saved = saved && !isZero
+				}
+				if saved {
+					created = append(created, createdRow{table: rel.FieldSchema.Table, key: key})
+				}
+			}
+			created = append(created, createdChildren(rel.FieldSchema, childPlan, children)...)
+		}
+	}
+	return created
+}
+
+// looks for an earlier run of the same file into the same model which is done, or still running.
+// The run has already been recorded, so of two uploads of the same file at once, the later one finds
+// the earlier one.  A run left running by a crash counts too, until it is marked failed
+func checkDuplicate(tx *gorm.DB, run *ImportRun, params Params, opts ImportOptions) error {
+	var earlier ImportRun
+	result := tx.Where("file_hash = ? AND model_table = ? AND status IN ? AND id < ?", run.FileHash, run.ModelTable, []string{ImportDone, ImportRunning}, run.ID).Order("id").Limit(1).Find(&earlier)
+	if result.Error != nil {
+		return fmt.Errorf("could not check for earlier imports: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+	switch opts.OnDuplicate {
+	case RefuseDuplicate:
+		return fmt.Errorf("%w into %v by import run %v", ErrDuplicateImport, run.ModelTable, earlier.ID)
+	case WarnDuplicate:
+		params.Report.Add(Issue{
+			Sheet:   run.Sheet,
+			Code:    IssueDuplicateImport,
+			Value:   fmt.Sprint(earlier.ID),
+			Message: run.FileName + " has already been imported into " + run.ModelTable + " by import run " + fmt.Sprint(earlier.ID),
+		})
+	}
+	run.DuplicateOf = &earlier.ID
+	return nil
+}
+
+// the database column of the meta:run field of a model, if it has one, as named by the naming strategy of the DB
+func runColumn(sch *schema.Schema) string {
+	plan, err := planFor(sch.ModelType)
+	if err != nil {
+		return ""
+	}
+	for _, fp := range plan.Fields {
+		if fp.Tag.IsMeta && fp.Tag.Meta == "run" {
+			if field := sch.LookUpField(fp.Name); field != nil {
+				return field.DBName
+			}
+		}
+	}
+	return ""
+}
+
+var deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+
+// the columns an upsert overwrites: those the sheet fills and the update time, but not the primary key
+// or the columns identifying the row.  Columns of the model which the sheet does not fill are left alone.
+// The deletion time is cleared, so a row soft deleted by UndoImport is imported again
+func upsertColumns(sch *schema.Schema, sp *sheetPlan, upsertOn []string) []string {
+	var columns []string
+	for _, field := range sch.Fields {
+		if field.DBName == "" || field.PrimaryKey {
+			continue
+		}
+		if _, found := find(upsertOn, field.DBName); found {
+			continue
+		}
+		if field.AutoUpdateTime > 0 || field.FieldType == deletedAtType || sp.fills(field.StructField.Index) {
+			columns = append(columns, field.DBName)
+		}
+	}
+	return columns
+}
+
+// the most values bound to a query for the keys of rows to be updated, within the limits of all databases
+const beforeImageBatchVars = 900
+
+// saves the rows which the records are about to overwrite as ImportBeforeImages, keeping the columns in
+// updateCols.  Returns the number of existing rows the records match
+func saveBeforeImages(tx *gorm.DB, run *ImportRun, sch *schema.Schema, records reflect.Value, upsertOn []string, updateCols []string) (int, error) {
+	keyFields := make([]*schema.Field, len(upsertOn))
+	keyCols := make([]clause.Column, len(upsertOn))
+	for i, col := range upsertOn {
+		if keyFields[i] = sch.LookUpField(col); keyFields[i] == nil {
+			return 0, errors.New("upsert column " + col + " is not a column of " + run.ModelTable)
+		}
+		keyCols[i] = clause.Column{Name: col}
+	}
+	if len(updateCols) > 0 && len(sch.PrimaryFieldDBNames) == 0 {
+		return 0, errors.New(run.ModelTable + " needs a primary key to restore the rows an upsert updates")
+	}
+
+	// the distinct keys of the records
+	var keys [][]interface{}
+	seen := make(map[string]bool, records.Len())
+	for i := 0; i < records.Len(); i++ {
+		key := make([]interface{}, len(keyFields))
+		for j, field := range keyFields {
+			key[j], _ = field.ValueOf(records.Index(i))
+		}
+		if id := fmt.Sprintf("%#v", key); !seen[id] {
+			seen[id] = true
+			keys = append(keys, key)
+		}
+	}
+
+	matched := 0
+	columns := append(append([]string(nil), sch.PrimaryFieldDBNames...), updateCols...)
+	batchSize := beforeImageBatchVars / len(keyCols)
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		// rows which are not updated are only counted
+		if len(updateCols) == 0 {
+			var count int64
+			if err := tx.Table(run.ModelTable).Where(keysIn(keyCols, keys[start:end])).Count(&count).Error; err != nil {
+				return matched, fmt.Errorf("could not count existing rows: %w", err)
+			}
+			matched += int(count)
+			continue
+		}
+		beforeImages, err := readBeforeImages(tx, run, columns, len(sch.PrimaryFieldDBNames), keyCols, keys[start:end])
+		if err != nil {
+			return matched, err
+		}
+		matched += len(beforeImages)
+		if len(beforeImages) == 0 {
+			continue
+		}
+		if err := tx.Create(&beforeImages).Error; err != nil {
+			return matched, fmt.Errorf("could not save rows to be updated: %w", err)
+		}
+	}
+	return matched, nil
+}
+
+// reads the rows matching keys as ImportBeforeImages.  The first pkCols columns are the primary key
+func readBeforeImages(tx *gorm.DB, run *ImportRun, columns []string, pkCols int, keyCols []clause.Column, keys [][]interface{}) ([]ImportBeforeImage, error) {
+	rows, err := tx.Table(run.ModelTable).Select(columns).Where(keysIn(keyCols, keys)).Rows()
+	if err != nil {
+		return nil, fmt.Errorf("could not read rows to be updated: %w", err)
+	}
+	defer rows.Close()
+
+	var beforeImages []ImportBeforeImage
+	values := make([]interface{}, len(columns))
+	for rows.Next() {
+		// scanned into interface{}, values keep the type the driver gives them, eg. []byte or time.Time
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, fmt.Errorf("could not read row to be updated: %w", err)
+		}
+		primaryKey := make(map[string]interface{}, pkCols)
+		data := make(map[string]interface{}, len(columns)-pkCols)
+		for i, col := range columns {
+			if i < pkCols {
+				primaryKey[col] = values[i]
+			} else {
+				data[col] = values[i]
+			}
+		}
+		pkGob, err := encodeGob(primaryKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode primary key of row to be updated: %w", err)
+		}
+		dataGob, err := encodeGob(data)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode row to be updated: %w", err)
+		}
+		beforeImages = append(beforeImages, ImportBeforeImage{
+			ImportRunID: run.ID,
+			ModelTable:  run.ModelTable,
+			PrimaryKey:  pkGob,
+			Data:        dataGob,
+		})
+	}
+	return beforeImages, rows.Err()
+}
+
+// records rows the run created in other tables than that of the model, so UndoImport can delete them
+func saveCreatedRows(tx *gorm.DB, run *ImportRun, created string, rows []createdRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	images := make([]ImportBeforeImage, len(rows))
+	for i, row := range rows {
+		key, err := encodeGob(row.key)
+		if err != nil {
+			return fmt.Errorf("could not encode key of row created in %v: %w", row.table, err)
+		}
+		images[i] = ImportBeforeImage{ImportRunID: run.ID, ModelTable: row.table, Created: created, PrimaryKey: key}
+	}
+	if err := tx.CreateInBatches(&images, fkBatchSize).Error; err != nil {
+		return fmt.Errorf("could not record rows created in %v: %w", rows[0].table, err)
+	}
+	return nil
+}
+
+// reverts an import made by ImportSheet: rows it updated are restored from their ImportBeforeImages,
+// and rows it created are deleted, or soft deleted if the table has a deleted_at column (eg. models
+// embedding gorm.Model).  The model needs a meta:run field to identify the rows created by the run.
+// Children of the records are deleted first, and parents created for fk: fields last, so no row is
+// deleted while others refer to it.
+// Everything is reverted in one transaction, and the run is marked as undone
+func UndoImport(db *gorm.DB, runID uint) error {
+	var run ImportRun
+	if err := db.First(&run, runID).Error; err != nil {
+		return fmt.Errorf("could not find import run %v: %w", runID, err)
+	}
+	if run.Status == ImportUndone {
+		return fmt.Errorf("import run %v has already been undone", runID)
+	}
+	if run.RunColumn == "" {
+		return fmt.Errorf("import run %v cannot be undone as %v has no meta:run field to identify its records", runID, run.ModelTable)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var beforeImages []ImportBeforeImage
+		if err := tx.Where("import_run_id = ?", run.ID).Order("id desc").Find(&beforeImages).Error; err != nil {
+			return fmt.Errorf("could not read rows updated by import run %v: %w", runID, err)
+		}
+
+		var updated, parents, children []ImportBeforeImage
+		for _, beforeImage := range beforeImages {
+			switch beforeImage.Created {
+			case ImportCreatedParent:
+				parents = append(parents, beforeImage)
+			case ImportCreatedChild:
+				children = append(children, beforeImage)
+			default:
+				updated = append(updated, beforeImage)
+			}
+		}
+
+		// children are saved after their parents, so the last saved, eg. children of children, are deleted first
+		if err := deleteCreatedRows(tx, children, now); err != nil {
+			return fmt.Errorf("could not delete rows created by import run %v: %w", runID, err)
+		}
+
+		// restoring the rows also restores their meta:run column, so they are not deleted below
+		tables := []string{run.ModelTable}
+		for _, beforeImage := range updated {
+			var primaryKey, data map[string]interface{}
+			if err := decodeGob(beforeImage.PrimaryKey, &primaryKey); err != nil {
+				return fmt.Errorf("could not decode primary key of updated row: %w", err)
+			}
+			if err := decodeGob(beforeImage.Data, &data); err != nil {
+				return fmt.Errorf("could not decode updated row: %w", err)
+			}
+			if err := tx.Table(beforeImage.ModelTable).Where(primaryKey).Updates(data).Error; err != nil {
+				return fmt.Errorf("could not restore row of %v: %w", beforeImage.ModelTable, err)
+			}
+			if _, found := find(tables, beforeImage.ModelTable); !found {
+				tables = append(tables, beforeImage.ModelTable)
+			}
+		}
+
+		for _, table := range tables {
+			columns, err := tableColumns(tx, table)
+			if err != nil {
+				return err
+			}
+			if _, found := find(columns, run.RunColumn); !found {
+				continue
+			}
+			createdByRun := clause.Eq{Column: clause.Column{Name: run.RunColumn}, Value: run.ID}
+			if _, softDelete := find(columns, "deleted_at"); softDelete {
+				err = tx.Table(table).Where(createdByRun).Where("deleted_at IS NULL").Update("deleted_at", now).Error
+			} else {
+				err = tx.Exec("DELETE FROM ? WHERE ? = ?", clause.Table{Name: table}, clause.Column{Name: run.RunColumn}, run.ID).Error
+			}
+			if err != nil {
+				return fmt.Errorf("could not delete rows of %v created by import run %v: %w", table, runID, err)
+			}
+		}
+
+		if err := deleteCreatedRows(tx, parents, now); err != nil {
+			return fmt.Errorf("could not delete rows created by import run %v: %w", runID, err)
+		}
+
+		run.Status = ImportUndone
+		run.UndoneAt = &now
+		return tx.Save(&run).Error
+	})
+}
+
+// deletes rows recorded by saveCreatedRows, or soft deletes them if their table has a deleted_at column
+func deleteCreatedRows(tx *gorm.DB, images []ImportBeforeImage, now time.Time) error {
+	// rows of the same table identified by the same columns are deleted together
+	type createdRows struct {
+		table   string
+		keyCols []clause.Column
+		keys    [][]interface{}
+	}
+	var groups []*createdRows
+	byColumns := make(map[string]*createdRows)
+	for _, image := range images {
+		var key map[string]interface{}
+		if err := decodeGob(image.PrimaryKey, &key); err != nil {
+			return fmt.Errorf("could not decode key of row created in %v: %w", image.ModelTable, err)
+		}
+		names := make([]string, 0, len(key))
+		for name := range key {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		id := image.ModelTable + "\x00" + strings.Join(names, "\x00")
+		group := byColumns[id]
+		if group == nil {
+			group = &createdRows{table: image.ModelTable}
+			for _, name := range names {
+				group.keyCols = append(group.keyCols, clause.Column{Name: name})
+			}
+			byColumns[id] = group
+			groups = append(groups, group)
+		}
+		values := make([]interface{}, len(names))
+		for i, name := range names {
+			values[i] = key[name]
+		}
+		group.keys = append(group.keys, values)
+	}
+
+	for _, group := range groups {
+		columns, err := tableColumns(tx, group.table)
+		if err != nil {
+			return err
+		}
+		_, softDelete := find(columns, "deleted_at")
+		batchSize := beforeImageBatchVars / len(group.keyCols)
+		for start := 0; start < len(group.keys); start += batchSize {
+			end := start + batchSize
+			if end > len(group.keys) {
+				end = len(group.keys)
+			}
+			query := tx.Table(group.table).Where(keysIn(group.keyCols, group.keys[start:end]))
+			if softDelete {
+				err = query.Where("deleted_at IS NULL").Update("deleted_at", now).Error
+			} else {
+				err = query.Delete(map[string]interface{}{}).Error
+			}
+			if err != nil {
+				return fmt.Errorf("could not delete rows of %v: %w", group.table, err)
+			}
+		}
+	}
+	return nil
+}
+
+// lists the columns of a table
+func tableColumns(db *gorm.DB, table string) ([]string, error) {
+	rows, err := db.Table(table).Limit(1).Rows()
+	if err != nil {
+		return nil, fmt.Errorf("could not read columns of %v: %w", table, err)
+	}
+	defer rows.Close()
+	return rows.Columns()
+}
+
+// matches rows with any of the keys.  Keys of several columns are matched with OR rather than a row value
+// IN list, which sqlite does not support
+func keysIn(keyCols []clause.Column, keys [][]interface{}) clause.Expression {
+	if len(keyCols) == 1 {
+		values := make([]interface{}, len(keys))
+		for i, key := range keys {
+			values[i] = key[0]
+		}
+		return clause.IN{Column: keyCols[0], Values: values}
+	}
+	matches := make([]clause.Expression, len(keys))
+	for i, key := range keys {
+		eqs := make([]clause.Expression, len(keyCols))
+		for j, col := range keyCols {
+			eqs[j] = clause.Eq{Column: col, Value: key[j]}
+		}
+		matches[i] = clause.And(eqs...)
+	}
+	return clause.Or(matches...)
+}
+
+func encodeGob(values map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(values)
+	return buf.Bytes(), err
+}
+
+func decodeGob(data []byte, values *map[string]interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(values)
+}
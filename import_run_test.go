@@ -0,0 +1,326 @@
+package excel_to_gorm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+type importFruit struct {
+	gorm.Model
+	Name  string `xtg:"col:Name" gorm:"uniqueIndex"`
+	Qty   int    `xtg:"col:Qty"`
+	RunID uint   `xtg:"meta:run"`
+}
+
+// a new sqlite database, with the tables of ImportSheet and the given models
+func openTestDB(t *testing.T, models ...interface{}) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(append([]interface{}{&ImportRun{}, &ImportBeforeImage{}}, models...)...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func fruitBook(t *testing.T, rows ...[]interface{}) []byte {
+	return mkBytes(t, map[string][][]interface{}{"S": append([][]interface{}{{"Name", "Qty"}}, rows...)})
+}
+
+func TestImportBytes(t *testing.T) {
+	db := openTestDB(t, &importFruit{})
+	data := fruitBook(t, []interface{}{"apple", 1}, []interface{}{"pear", 2})
+	run, err := ImportBytes(db, "fruit.xlsx", data, "S", &importFruit{}, Params{}, ImportOptions{Tags: map[string]string{"user": "sam"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != ImportDone || run.RowsRead != 2 || run.RecordsCreated != 2 || run.Tags != `{"user":"sam"}` {
+		t.Errorf("got run %+v", run)
+	}
+	var fruits []importFruit
+	db.Order("name").Find(&fruits)
+	if len(fruits) != 2 || fruits[0].Name != "apple" || fruits[0].RunID != run.ID {
+		t.Errorf("got %+v", fruits)
+	}
+}
+
+func TestImportFailureRollsBack(t *testing.T) {
+	db := openTestDB(t, &importFruit{})
+	data := fruitBook(t, []interface{}{"apple", 1}, []interface{}{"apple", 2})
+	run, err := ImportBytes(db, "fruit.xlsx", data, "S", &importFruit{}, Params{}, ImportOptions{})
+	if err == nil || run == nil || run.Status != ImportFailed || run.Errors == "" {
+		t.Fatalf("got run %+v, error %v", run, err)
+	}
+	var count int64
+	db.Model(&importFruit{}).Count(&count)
+	if count != 0 {
+		t.Errorf("got %v records after a failed import, want 0", count)
+	}
+}
+
+func TestDuplicateImport(t *testing.T) {
+	db := openTestDB(t, &importFruit{})
+	first, err := ImportBytes(db, "fruit.xlsx", fruitBook(t, []interface{}{"apple", 1}), "S", &importFruit{}, Params{}, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run, err := ImportBytes(db, "again.xlsx", fruitBook(t, []interface{}{"apple", 1}), "S", &importFruit{}, Params{}, ImportOptions{})
+	if !errors.Is(err, ErrDuplicateImport) || run != nil {
+		t.Errorf("got run %+v, error %v, want ErrDuplicateImport", run, err)
+	}
+	var runs int64
+	db.Model(&ImportRun{}).Count(&runs)
+	if runs != 1 {
+		t.Errorf("got %v runs, want the refused run to be removed", runs)
+	}
+
+	db.Unscoped().Where("1 = 1").Delete(&importFruit{})
+	report := &Report{}
+	run, err = ImportBytes(db, "again.xlsx", fruitBook(t, []interface{}{"apple", 1}), "S", &importFruit{}, Params{Report: report}, ImportOptions{OnDuplicate: WarnDuplicate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.DuplicateOf == nil || *run.DuplicateOf != first.ID {
+		t.Errorf("got DuplicateOf %v, want %v", run.DuplicateOf, first.ID)
+	}
+	if issues := report.Issues(); len(issues) != 1 || issues[0].Code != IssueDuplicateImport {
+		t.Errorf("got issues %v", issues)
+	}
+}
+
+func TestDuplicateOfRunningImport(t *testing.T) {
+	db := openTestDB(t, &importFruit{})
+	data := fruitBook(t, []interface{}{"apple", 1})
+	// another upload of the same file which has not finished yet
+	first, _ := ImportBytes(db, "first.xlsx", data, "S", &importFruit{}, Params{}, ImportOptions{})
+	db.Model(first).Update("status", ImportRunning)
+	db.Unscoped().Where("1 = 1").Delete(&importFruit{})
+
+	if _, err := ImportBytes(db, "second.xlsx", data, "S", &importFruit{}, Params{}, ImportOptions{}); !errors.Is(err, ErrDuplicateImport) {
+		t.Errorf("got %v, want ErrDuplicateImport", err)
+	}
+}
+
+type upsertFruit struct {
+	ID     uint
+	Name   string    `xtg:"col:Name" gorm:"uniqueIndex"`
+	Qty    int       `xtg:"col:Qty"`
+	Picked time.Time `xtg:"col:Picked"`
+	Blob   []byte
+	Note   string // not in the sheet, so left alone by the upsert
+	RunID  uint   `xtg:"meta:run"`
+}
+
+func TestUpsertAndUndo(t *testing.T) {
+	db := openTestDB(t, &upsertFruit{})
+	picked := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	before := upsertFruit{Name: "apple", Qty: 1, Picked: picked, Blob: []byte{1, 2, 3}, Note: "keep"}
+	if err := db.Create(&before).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	data := mkBytes(t, map[string][][]interface{}{"S": {
+		{"Name", "Qty", "Picked"},
+		{"apple", 10, picked.AddDate(0, 1, 0)},
+		{"plum", 3, picked},
+	}})
+	params := Params{Derive: map[string]func(RowView) (interface{}, error){
+		"Blob": func(RowView) (interface{}, error) { return []byte{4, 5, 6}, nil },
+	}}
+	run, err := ImportBytes(db, "fruit.xlsx", data, "S", &upsertFruit{}, params, ImportOptions{UpsertOn: []string{"name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.RecordsCreated != 1 || run.RecordsUpdated != 1 {
+		t.Errorf("got %v records created and %v updated, want 1 of each", run.RecordsCreated, run.RecordsUpdated)
+	}
+	var apple upsertFruit
+	db.Where("name = ?", "apple").First(&apple)
+	if apple.Qty != 10 || apple.Note != "keep" || apple.RunID != run.ID || !bytes.Equal(apple.Blob, []byte{4, 5, 6}) {
+		t.Errorf("after upsert got %+v", apple)
+	}
+
+	if err := UndoImport(db, run.ID); err != nil {
+		t.Fatal(err)
+	}
+	var fruits []upsertFruit
+	db.Find(&fruits)
+	if len(fruits) != 1 {
+		t.Fatalf("after undo got %+v, want only the apple", fruits)
+	}
+	got := fruits[0]
+	if got.Qty != 1 || !got.Picked.Equal(picked) || !bytes.Equal(got.Blob, []byte{1, 2, 3}) || got.Note != "keep" || got.RunID != 0 {
+		t.Errorf("after undo got %+v, want %+v", got, before)
+	}
+	if err := UndoImport(db, run.ID); err == nil {
+		t.Error("expected an error undoing a run twice")
+	}
+}
+
+func TestUpsertManyRows(t *testing.T) {
+	db := openTestDB(t, &importFruit{})
+	rows := make([][]interface{}, 0, 2*beforeImageBatchVars)
+	for i := 0; i < 2*beforeImageBatchVars; i++ {
+		rows = append(rows, []interface{}{fmt.Sprint("fruit", i), i})
+	}
+	if _, err := ImportBytes(db, "first.xlsx", fruitBook(t, rows...), "S", &importFruit{}, Params{}, ImportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		row[1] = -1
+	}
+	run, err := ImportBytes(db, "second.xlsx", fruitBook(t, rows...), "S", &importFruit{}, Params{}, ImportOptions{UpsertOn: []string{"name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var images int64
+	db.Model(&ImportBeforeImage{}).Where("import_run_id = ?", run.ID).Count(&images)
+	if images != int64(len(rows)) {
+		t.Errorf("got %v before images, want %v", images, len(rows))
+	}
+	if err := UndoImport(db, run.ID); err != nil {
+		t.Fatal(err)
+	}
+	var changed int64
+	db.Model(&importFruit{}).Where("qty = -1").Count(&changed)
+	if changed != 0 {
+		t.Errorf("%v rows were not restored", changed)
+	}
+}
+
+type farmFruit struct {
+	ID    uint
+	Name  string `xtg:"col:Name" gorm:"uniqueIndex:idx_farm_fruit"`
+	Farm  string `xtg:"col:Farm" gorm:"uniqueIndex:idx_farm_fruit"`
+	Qty   int    `xtg:"col:Qty"`
+	RunID uint   `xtg:"meta:run"`
+}
+
+func TestUpsertOnSeveralColumns(t *testing.T) {
+	db := openTestDB(t, &farmFruit{})
+	db.Create(&[]farmFruit{{Name: "apple", Farm: "north", Qty: 1}, {Name: "apple", Farm: "south", Qty: 2}})
+	data := mkBytes(t, map[string][][]interface{}{"S": {{"Name", "Farm", "Qty"}, {"apple", "north", 5}, {"apple", "east", 6}}})
+	run, err := ImportBytes(db, "fruit.xlsx", data, "S", &farmFruit{}, Params{}, ImportOptions{UpsertOn: []string{"name", "farm"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.RecordsCreated != 1 || run.RecordsUpdated != 1 {
+		t.Errorf("got %v records created and %v updated, want 1 of each", run.RecordsCreated, run.RecordsUpdated)
+	}
+	var images int64
+	db.Model(&ImportBeforeImage{}).Count(&images)
+	if images != 1 {
+		t.Errorf("got %v before images, want 1 for apple from the north", images)
+	}
+	if err := UndoImport(db, run.ID); err != nil {
+		t.Fatal(err)
+	}
+	var fruits []farmFruit
+	db.Order("farm").Find(&fruits)
+	if len(fruits) != 2 || fruits[0].Qty != 1 || fruits[1].Qty != 2 {
+		t.Errorf("after undo got %+v", fruits)
+	}
+}
+
+type batchFruit struct {
+	ID      uint
+	Name    string `xtg:"col:Name"`
+	BatchID uint   `xtg:"meta:run"`
+}
+
+// the meta:run column is named by the naming strategy of the DB, rather than gorm's default
+func TestCustomNamingStrategy(t *testing.T) {
+	namer := schema.NamingStrategy{NameReplacer: strings.NewReplacer("Batch", "Lot")}
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{NamingStrategy: namer, Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&ImportRun{}, &ImportBeforeImage{}, &batchFruit{}); err != nil {
+		t.Fatal(err)
+	}
+	run, err := ImportBytes(db, "fruit.xlsx", fruitBook(t, []interface{}{"apple", 1}), "S", &batchFruit{}, Params{}, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.RunColumn != "lot_id" {
+		t.Errorf("got run column %v, expected lot_id", run.RunColumn)
+	}
+	if err := UndoImport(db, run.ID); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&batchFruit{}).Count(&count)
+	if count != 0 {
+		t.Errorf("got %v fruits after undo", count)
+	}
+}
+
+// a row soft deleted by UndoImport is brought back by a later upsert of the same key
+func TestUpsertAfterUndo(t *testing.T) {
+	db := openTestDB(t, &importFruit{})
+	opts := ImportOptions{UpsertOn: []string{"name"}, OnDuplicate: AllowDuplicate}
+	first, err := ImportBytes(db, "fruit.xlsx", fruitBook(t, []interface{}{"apple", 1}), "S", &importFruit{}, Params{}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UndoImport(db, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	second, err := ImportBytes(db, "fruit.xlsx", fruitBook(t, []interface{}{"apple", 2}), "S", &importFruit{}, Params{}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var apple importFruit
+	if err := db.Where("name = ?", "apple").First(&apple).Error; err != nil {
+		t.Fatalf("expected the apple to be imported again: %v", err)
+	}
+	if apple.Qty != 2 || apple.RunID != second.ID {
+		t.Errorf("got %+v", apple)
+	}
+
+	// undoing the second import deletes the apple again
+	if err := UndoImport(db, second.ID); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&importFruit{}).Count(&count)
+	if count != 0 {
+		t.Errorf("got %v fruits after undo", count)
+	}
+}
+
+type keyFruit struct {
+	ID   uint
+	Name string `xtg:"col:Name" gorm:"uniqueIndex"`
+}
+
+// with nothing to update, records matching existing rows are left out rather than counted as created
+func TestUpsertWithNothingToUpdate(t *testing.T) {
+	db := openTestDB(t, &keyFruit{})
+	db.Create(&keyFruit{Name: "apple"})
+	data := mkBytes(t, map[string][][]interface{}{"S": {{"Name"}, {"apple"}, {"pear"}}})
+	run, err := ImportBytes(db, "fruit.xlsx", data, "S", &keyFruit{}, Params{}, ImportOptions{UpsertOn: []string{"name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.RecordsCreated != 1 || run.RecordsUpdated != 0 {
+		t.Errorf("got %v records created and %v updated, want 1 created", run.RecordsCreated, run.RecordsUpdated)
+	}
+	var count int64
+	db.Model(&keyFruit{}).Count(&count)
+	if count != 2 {
+		t.Errorf("got %v fruits", count)
+	}
+}
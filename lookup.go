@@ -0,0 +1,104 @@
+package excel_to_gorm
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/c4rnot/csv_to_gorm"
+	"github.com/tealeg/xlsx/v3"
+)
+
+// UnmappedPolicy says what happens when the text of a cell is not in the lookup: of its field
+type UnmappedPolicy int
+
+const (
+	UnmappedFail  UnmappedPolicy = iota // stop the conversion with an error
+	UnmappedKeep                        // report the value and convert it as it is
+	UnmappedEmpty                       // report the value and leave the field empty
+)
+
+// a lookup: table resolved against Params.Lookups for a field
+type fieldLookup struct {
+	name       string
+	values     map[string]string // keys are lower case if ci
+	ci         bool
+	hasDefault bool
+	dflt       string
+}
+
+// resolves the lookup: tag of a field.  nil if it has none
+func newFieldLookup(fp *FieldPlan, params Params) (*fieldLookup, error) {
+	if fp.Tag.Lookup == "" {
+		return nil, nil
+	}
+	values, ok := params.Lookups[fp.Tag.Lookup]
+	if !ok {
+		return nil, errors.New("field " + fp.Name + " uses lookup " + fp.Tag.Lookup + " which is not in Params.Lookups")
+	}
+	lk := &fieldLookup{name: fp.Tag.Lookup, values: values, ci: fp.Tag.LookupCI, hasDefault: fp.Tag.HasLookupDefault, dflt: fp.Tag.LookupDefault}
+	if lk.ci {
+		lk.values = make(map[string]string, len(values))
+		for key, value := range values {
+			lk.values[strings.ToLower(strings.TrimSpace(key))] = value
+		}
+	}
+	return lk, nil
+}
+
+// maps text through the lookup.  ok is false if it is not there and the lookup has no default
+func (lk *fieldLookup) find(text string) (string, bool) {
+	key := strings.TrimSpace(text)
+	if lk.ci {
+		key = strings.ToLower(key)
+	}
+	if value, ok := lk.values[key]; ok {
+		return value, true
+	}
+	if lk.hasDefault {
+		return lk.dflt, true
+	}
+	return "", false
+}
+
+// the cell a field reads with its text mapped through the lookup: of the field.  Empty cells are
+// not looked up.  ok is false if the field should be left empty
+func (sp *sheetPlan) lookupCell(cv CellValue, row int, col int, fldIx int) (mapped CellValue, ok bool, err error) {
+	lk := sp.lookups[fldIx]
+	if cv.Kind == CellEmpty {
+		return cv, true, nil
+	}
+	if value, found := lk.find(cv.Value); found {
+		// the mapped text is read as text, as if from a CSV file
+		return csvCellValue(value), true, nil
+	}
+	keep, err := sp.unmapped(cv.Value, row, col, fldIx)
+	return cv, keep, err
+}
+
+// the value of a field read from a melt or int column heading, mapped through the lookup: of the field.
+// Missing headings are reported against the heading cell
+func (sp *sheetPlan) lookupHeading(heading string, col int, fldIx int) (reflect.Value, error) {
+	fp := &sp.plan.Fields[fldIx]
+	value, found := sp.lookups[fldIx].find(heading)
+	if !found {
+		keep, err := sp.unmapped(heading, sp.hdgRowNum, col, fldIx)
+		if err != nil || !keep {
+			return reflect.Zero(fp.Type), err
+		}
+		value = heading
+	}
+	return csv_to_gorm.StringToType(value, fp.Type, sp.csvParams), nil
+}
+
+// applies Params.OnUnmapped to text missing from a lookup.  keep is false if the field should be left empty
+func (sp *sheetPlan) unmapped(text string, row int, col int, fldIx int) (keep bool, err error) {
+	fp := &sp.plan.Fields[fldIx]
+	lk := sp.lookups[fldIx]
+	message := text + " is not in lookup " + lk.name
+	if sp.params.OnUnmapped == UnmappedFail {
+		return false, errors.New(message)
+	}
+	sp.params.Report.Add(Issue{Sheet: sp.sheetName, Row: row + 1, Cell: xlsx.GetCellIDStringFromCoords(col, row), Field: fp.Name, Code: IssueUnmapped, Value: text, Message: message})
+	return sp.params.OnUnmapped == UnmappedKeep, nil
+}
@@ -0,0 +1,78 @@
+package excel_to_gorm
+
+import "testing"
+
+type lookupRow struct {
+	Name   string  `xtg:"col:Name"`
+	Kind   int     `xtg:"col:Kind,lookup:kinds;ci"`
+	Origin string  `xtg:"col:Origin,lookup:countries;default=Other"`
+	Cause  string  `xtg:"melt:colname,lookup:causes"`
+	Loss   float64 `xtg:"melt:value"`
+	Ok     bool    `xtg:"col:Ok,lookup:yn"`
+}
+
+var testLookups = map[string]map[string]string{
+	"kinds":     {"Cooking Apple": "1", "eating": "2"},
+	"countries": {"UK": "United Kingdom"},
+	"causes":    {"Scab": "scab fungus", "Rot": "brown rot"},
+	"yn":        {"Oui": "true", "Non": "false"},
+}
+
+func TestLookups(t *testing.T) {
+	rows := [][]string{{"Name", "Kind", "Origin", "Ok", "Scab", "Rot"}, {"Gala", " cooking APPLE ", "UK", "Oui", "1", "2"}, {"Fuji", "Eating", "JP", "Non", "3", ""}}
+	out, err := SourceToSlice(NewGridSource("s", rows), &lookupRow{}, Params{Lookups: testLookups})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]lookupRow)
+	want := []lookupRow{
+		{"Gala", 1, "United Kingdom", "scab fungus", 1, true},
+		{"Gala", 1, "United Kingdom", "brown rot", 2, true},
+		{"Fuji", 2, "Other", "scab fungus", 3, false},
+	}
+	if len(got) != 4 {
+		t.Fatalf("got %+v", got)
+	}
+	for i, w := range want {
+		if got[i] != w {
+			t.Errorf("record %v got %+v, want %+v", i, got[i], w)
+		}
+	}
+}
+
+func TestUnmappedPolicies(t *testing.T) {
+	rows := [][]string{{"Name", "Kind", "Origin", "Ok", "Scab", "Blight"}, {"Gala", "Cooking apple", "", "Oui", "1", "2"}, {"Fuji", "1", "UK", "Non", "3", "4"}}
+	if _, err := SourceToSlice(NewGridSource("s", rows), &lookupRow{}, Params{Lookups: testLookups}); err == nil {
+		t.Error("expected an error for text missing from a lookup with UnmappedFail")
+	}
+
+	report := &Report{}
+	out, err := SourceToSlice(NewGridSource("s", rows), &lookupRow{}, Params{Lookups: testLookups, OnUnmapped: UnmappedKeep, Report: report})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]lookupRow)
+	// Blight and the Kind 1 are kept as they are.  The empty Origin is not looked up
+	if got[1].Cause != "Blight" || got[3].Kind != 1 || got[0].Origin != "" {
+		t.Errorf("got %+v", got)
+	}
+	issues := report.Issues()
+	if len(issues) != 2 || issues[0].Code != IssueUnmapped || issues[0].Cell != "F1" || issues[1].Cell != "B3" || issues[1].Value != "1" {
+		t.Errorf("got issues %+v", issues)
+	}
+
+	out, err = SourceToSlice(NewGridSource("s", rows), &lookupRow{}, Params{Lookups: testLookups, OnUnmapped: UnmappedEmpty})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.([]lookupRow); got[1].Cause != "" || got[3].Kind != 0 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestMissingLookup(t *testing.T) {
+	rows := [][]string{{"Name", "Kind"}, {"Gala", "eating"}}
+	if _, err := SourceToSlice(NewGridSource("s", rows), &lookupRow{}, Params{}); err == nil {
+		t.Error("expected an error for a lookup missing from Params.Lookups")
+	}
+}
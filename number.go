@@ -0,0 +1,272 @@
+package excel_to_gorm
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// the separators used to write numbers in a locale
+type numberLocale struct {
+	decimal   rune
+	thousands rune
+}
+
+// separators by locale, or by language if the locale is not listed.  Spaces are always accepted between
+// groups of thousands, as they are written in many locales and never mean anything else in a number
+var numberLocales = map[string]numberLocale{
+	"en":    {'.', ','},
+	"ja":    {'.', ','},
+	"zh":    {'.', ','},
+	"ko":    {'.', ','},
+	"he":    {'.', ','},
+	"th":    {'.', ','},
+	"de":    {',', '.'},
+	"nl":    {',', '.'},
+	"it":    {',', '.'},
+	"es":    {',', '.'},
+	"pt":    {',', '.'},
+	"da":    {',', '.'},
+	"id":    {',', '.'},
+	"tr":    {',', '.'},
+	"el":    {',', '.'},
+	"ro":    {',', '.'},
+	"hr":    {',', '.'},
+	"sl":    {',', '.'},
+	"sr":    {',', '.'},
+	"fr":    {',', ' '},
+	"ru":    {',', ' '},
+	"uk":    {',', ' '},
+	"pl":    {',', ' '},
+	"cs":    {',', ' '},
+	"sk":    {',', ' '},
+	"hu":    {',', ' '},
+	"sv":    {',', ' '},
+	"fi":    {',', ' '},
+	"nb":    {',', ' '},
+	"no":    {',', ' '},
+	"et":    {',', ' '},
+	"lt":    {',', ' '},
+	"lv":    {',', ' '},
+	"bg":    {',', ' '},
+	"de-ch": {'.', '\''},
+	"fr-ch": {'.', '\''},
+	"it-ch": {'.', '\''},
+	"de-li": {'.', '\''},
+	"en-za": {',', ' '},
+	"es-mx": {'.', ','},
+	"pt-br": {',', '.'},
+}
+
+// the separators for a locale such as "de", "de-CH" or "fr_FR".  "" is English
+func lookupNumberLocale(locale string) (numberLocale, error) {
+	name := strings.ToLower(strings.Replace(strings.TrimSpace(locale), "_", "-", -1))
+	if name == "" {
+		return numberLocales["en"], nil
+	}
+	if loc, ok := numberLocales[name]; ok {
+		return loc, nil
+	}
+	if i := strings.IndexByte(name, '-'); i > 0 {
+		if loc, ok := numberLocales[name[:i]]; ok {
+			return loc, nil
+		}
+	}
+	return numberLocale{}, errors.New("unknown locale " + locale)
+}
+
+// parses a number written as text, such as 1.234,56 (with a German locale), 1 234,56, 12%, $1,200,
+// (500) for an accounting negative, or 1.2E3.  Percentages are divided by 100
+func parseNumber(s string, loc numberLocale) (float64, error) {
+	n, err := splitNumber(s, loc)
+	if err != nil {
+		return 0, err
+	}
+	return n.float()
+}
+
+// parses a whole number written as text, as parseNumber.  Returns its decimal digits, with a leading -
+// if it is negative.  Numbers without a fraction or exponent are not read through a float64, so that
+// integers beyond 2^53 keep every digit
+func parseWholeNumber(s string, loc numberLocale) (string, error) {
+	n, err := splitNumber(s, loc)
+	if err != nil {
+		return "", err
+	}
+	if strings.Trim(n.fraction, "0") == "" && n.exponent == "" && !n.percent {
+		digits := n.digits
+		if digits == "" {
+			digits = "0"
+		}
+		if n.negative {
+			digits = "-" + digits
+		}
+		return digits, nil
+	}
+	f, err := n.float()
+	if err != nil {
+		return "", err
+	}
+	if f != math.Trunc(f) {
+		return "", errors.New(s + " is not a whole number")
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64), nil
+}
+
+// the parts of a number written as text
+type numberText struct {
+	text     string // as written
+	negative bool
+	digits   string // of the whole part, without separators
+	fraction string // digits after the decimal separator
+	exponent string // eg. "E-3"
+	percent  bool
+}
+
+// splits a number written as text into its parts, checking the separators are in the right places
+func splitNumber(s string, loc numberLocale) (numberText, error) {
+	invalid := errors.New("could not read " + s + " as a number")
+	n := numberText{text: s}
+	text := strings.TrimSpace(s)
+
+	if strings.HasPrefix(text, "(") && strings.HasSuffix(text, ")") {
+		n.negative = true
+		text = strings.TrimSpace(text[1 : len(text)-1])
+	}
+
+	// currency symbols, percent signs and signs may be before or after the number, eg. -$5 or 5 €
+	var sign string
+	trim := func(r rune) bool {
+		switch {
+		case unicode.Is(unicode.Sc, r), unicode.IsSpace(r):
+			return true
+		case r == '%' && !n.percent:
+			n.percent = true
+			return true
+		case (r == '-' || r == '+' || r == '−') && sign == "":
+			sign = string(r)
+			return true
+		}
+		return false
+	}
+	text = strings.TrimFunc(text, trim)
+	if sign == "-" || sign == "−" {
+		n.negative = !n.negative
+	}
+	if text == "" {
+		return n, invalid
+	}
+
+	// the exponent, if any, is after the mantissa, eg. 1,2E-3
+	mantissa := text
+	if i := strings.IndexAny(text, "eE"); i >= 0 {
+		mantissa, n.exponent = text[:i], text[i:]
+	}
+	whole := mantissa
+	if i := strings.IndexRune(mantissa, loc.decimal); i >= 0 {
+		whole, n.fraction = mantissa[:i], mantissa[i+len(string(loc.decimal)):]
+		if n.fraction != "" && !isDigits(n.fraction) {
+			return n, invalid
+		}
+	}
+	var ok bool
+	if n.digits, ok = ungroup(whole, loc.thousands); !ok || (n.digits == "" && n.fraction == "") {
+		return n, invalid
+	}
+	return n, nil
+}
+
+// the value of the number, with percentages divided by 100
+func (n numberText) float() (float64, error) {
+	var sb strings.Builder
+	if n.negative {
+		sb.WriteByte('-')
+	}
+	sb.WriteString(n.digits)
+	if n.fraction != "" {
+		sb.WriteByte('.')
+		sb.WriteString(n.fraction)
+	}
+	sb.WriteString(n.exponent)
+	f, err := strconv.ParseFloat(sb.String(), 64)
+	if err != nil {
+		return 0, errors.New("could not read " + n.text + " as a number")
+	}
+	if n.percent {
+		f /= 100
+	}
+	return f, nil
+}
+
+// removes the separators between groups of thousands, checking they are in the right places.
+// Groups of two are allowed before the last group, as in India, eg. 1,00,000
+func ungroup(whole string, thousands rune) (string, bool) {
+	var groups []string
+	start := 0
+	for i, r := range whole {
+		if r == thousands || unicode.IsSpace(r) {
+			groups = append(groups, whole[start:i])
+			start = i + len(string(r))
+		}
+	}
+	groups = append(groups, whole[start:])
+	if len(groups) == 1 {
+		return whole, isDigits(whole) || whole == ""
+	}
+	for i, g := range groups {
+		switch {
+		case !isDigits(g):
+			return "", false
+		case i == 0:
+			if len(g) > 3 {
+				return "", false
+			}
+		case i == len(groups)-1:
+			if len(g) != 3 {
+				return "", false
+			}
+		case len(g) != 2 && len(g) != 3:
+			return "", false
+		}
+	}
+	return strings.Join(groups, ""), true
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// whether a cell holds a number written as text, to be read by parseNumber rather than as stored.
+// Numbers guessed from text, eg. in CSV files, are read by parseNumber when a locale is set, as
+// 1.234 may mean 1234
+func isTextNumber(cv CellValue, params Params) bool {
+	return cv.Kind == CellString || (cv.fromText && params.Locale != "")
+}
+
+// reads a number written as text in the locale of params
+func textToNumber(cv CellValue, params Params) (float64, error) {
+	loc, err := lookupNumberLocale(params.Locale)
+	if err != nil {
+		return 0, err
+	}
+	return parseNumber(cv.Value, loc)
+}
+
+// reads a whole number written as text in the locale of params, as the digits of parseWholeNumber
+func textToWholeNumber(cv CellValue, params Params) (string, error) {
+	loc, err := lookupNumberLocale(params.Locale)
+	if err != nil {
+		return "", err
+	}
+	return parseWholeNumber(cv.Value, loc)
+}
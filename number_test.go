@@ -0,0 +1,96 @@
+package excel_to_gorm
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		in     string
+		locale string
+		want   float64
+	}{
+		{"1,234.5", "", 1234.5},
+		{"1.234,5", "de", 1234.5},
+		{"1 234,5", "fr", 1234.5},
+		{"1'234.5", "de-CH", 1234.5},
+		{"12%", "", 0.12},
+		{"$1,200", "", 1200},
+		{"-$5", "", -5},
+		{"5 €", "de", 5},
+		{"(500)", "", -500},
+		{"(-500)", "", 500},
+		{"1,2E3", "de", 1200},
+		{".5", "", 0.5},
+	}
+	for _, tt := range tests {
+		loc, err := lookupNumberLocale(tt.locale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseNumber(tt.in, loc)
+		if err != nil || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("parseNumber(%q, %q) got %v, %v, want %v", tt.in, tt.locale, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "abc", "1,23.4", "1.2.3", "12,34", "%", "1.2x"} {
+		if got, err := parseNumber(in, numberLocales["en"]); err == nil {
+			t.Errorf("parseNumber(%q) got %v, expected an error", in, got)
+		}
+	}
+	if _, err := lookupNumberLocale("tlh"); err == nil {
+		t.Error("expected an error for an unknown locale")
+	}
+}
+
+type wholeNumbers struct {
+	Big   int64  `xtg:"col:Big"`
+	UBig  uint64 `xtg:"col:UBig"`
+	Small int8   `xtg:"col:Small"`
+	Count uint   `xtg:"col:Count"`
+}
+
+func TestWholeNumbersAsText(t *testing.T) {
+	rows := [][]string{{"Big", "UBig", "Small", "Count"}, {"9007199254740993", "18446744073709551615", "-128", "1,234"}}
+	out, err := SourceToSlice(NewGridSource("s", rows), &wholeNumbers{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := wholeNumbers{9007199254740993, math.MaxUint64, -128, 1234}
+	if got := out.([]wholeNumbers)[0]; got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// numbers read from csv files are text in the given locale
+	out, err = CSVToSlice(strings.NewReader("Big,UBig,Small,Count\n9.007.199.254.740.993,1.000,\"1,0\",\"1E3\"\n"), &wholeNumbers{}, Params{Locale: "de", CSVDelimiter: ','})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = wholeNumbers{9007199254740993, 1000, 1, 1000}
+	if got := out.([]wholeNumbers)[0]; got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestInvalidWholeNumbers(t *testing.T) {
+	tests := []struct {
+		field string
+		in    string
+	}{
+		{"Big", "2.5"},
+		{"Big", "12%"},
+		{"Big", "9223372036854775808"},
+		{"Small", "128"},
+		{"Count", "-1"},
+		{"UBig", "18446744073709551616"},
+		{"Count", "1e400"},
+	}
+	for _, tt := range tests {
+		rows := [][]string{{tt.field}, {tt.in}}
+		if out, err := SourceToSlice(NewGridSource("s", rows), &wholeNumbers{}, Params{}); err == nil {
+			t.Errorf("%v %v got %+v, expected an error", tt.field, tt.in, out)
+		}
+	}
+}
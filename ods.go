@@ -0,0 +1,367 @@
+package excel_to_gorm
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reader for OpenDocument spreadsheets (.ods), as saved by LibreOffice.  The cells are read from
+// content.xml.  Styles are not read, so the displayed text of a cell is taken from its paragraphs
+
+// the mimetype entry of an OpenDocument spreadsheet starts with this.  Templates (.ots) add -template
+const odsMimeType = "application/vnd.oasis.opendocument.spreadsheet"
+
+const (
+	odsOfficeNS = "urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+	odsTableNS  = "urn:oasis:names:tc:opendocument:xmlns:table:1.0"
+	odsTextNS   = "urn:oasis:names:tc:opendocument:xmlns:text:1.0"
+)
+
+// the zero of excel serial dates.  Dates and times of .ods cells are converted to serials, as stored by .xlsx
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// whether a zip file is an OpenDocument spreadsheet, from its mimetype entry
+func isODS(zr *zip.Reader) bool {
+	for _, f := range zr.File {
+		if f.Name != "mimetype" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return false
+		}
+		defer rc.Close()
+		mimeType, err := io.ReadAll(io.LimitReader(rc, 256))
+		return err == nil && strings.HasPrefix(strings.TrimSpace(string(mimeType)), odsMimeType)
+	}
+	return false
+}
+
+// parses the sheets of an OpenDocument spreadsheet
+func parseODS(zr *zip.Reader) (*gridBook, error) {
+	var content *zip.File
+	for _, f := range zr.File {
+		if f.Name == "content.xml" {
+			content = f
+		}
+	}
+	if content == nil {
+		return nil, errors.New("spreadsheet has no content.xml")
+	}
+	rc, err := content.Open()
+	if err != nil {
+		return nil, fmt.Errorf("could not read content.xml: %w", err)
+	}
+	defer rc.Close()
+
+	book := &gridBook{}
+	var sh *gridSheet
+	var row []CellValue
+	rowRepeat := 1
+	// empty rows and cells are only added when followed by data, as LibreOffice pads sheets with
+	// up to a million repeated empty rows
+	emptyRows := 0
+	emptyCells := 0
+
+	dec := xml.NewDecoder(rc)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse content.xml: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space != odsTableNS {
+				continue
+			}
+			switch t.Name.Local {
+			case "table":
+				sh = &gridSheet{name: odsAttr(t, odsTableNS, "name")}
+				emptyRows = 0
+			case "table-row":
+				row = nil
+				emptyCells = 0
+				rowRepeat = odsRepeat(t, "number-rows-repeated")
+			case "table-cell", "covered-table-cell":
+				if sh == nil {
+					continue
+				}
+				cv, err := odsCell(dec, t)
+				if err != nil {
+					return nil, fmt.Errorf("could not parse sheet: %v. %w", sh.name, err)
+				}
+				repeat := odsRepeat(t, "number-columns-repeated")
+				cols := odsRepeat(t, "number-columns-spanned")
+				rows := odsRepeat(t, "number-rows-spanned")
+				if cols > 1 || rows > 1 {
+					firstRow, firstCol := len(sh.rows)+emptyRows, len(row)+emptyCells
+					sh.merged = append(sh.merged, MergedRange{FirstRow: firstRow, FirstCol: firstCol, LastRow: firstRow + rows - 1, LastCol: firstCol + cols - 1})
+				}
+				if cv.Kind == CellEmpty && cv.Formula == "" {
+					emptyCells += repeat
+					continue
+				}
+				if emptyCells > 0 {
+					row = append(row, make([]CellValue, emptyCells)...)
+					emptyCells = 0
+				}
+				for i := 0; i < repeat; i++ {
+					row = append(row, cv)
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Space != odsTableNS || sh == nil {
+				continue
+			}
+			switch t.Name.Local {
+			case "table-row":
+				if len(row) == 0 {
+					emptyRows += rowRepeat
+					continue
+				}
+				for ; emptyRows > 0; emptyRows-- {
+					sh.rows = append(sh.rows, nil)
+				}
+				// repeated rows share their cells, which are never changed
+				for i := 0; i < rowRepeat; i++ {
+					sh.rows = append(sh.rows, row)
+				}
+				if len(row) > sh.maxCol {
+					sh.maxCol = len(row)
+				}
+			case "table":
+				book.sheets = append(book.sheets, sh)
+				sh = nil
+			}
+		}
+	}
+	return book, nil
+}
+
+// the value of an attribute, or "" if it has none
+func odsAttr(el xml.StartElement, space string, local string) string {
+	for _, attr := range el.Attr {
+		if attr.Name.Space == space && attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// the number of times a row or cell is repeated
+func odsRepeat(el xml.StartElement, attr string) int {
+	repeat, err := strconv.Atoi(odsAttr(el, odsTableNS, attr))
+	if err != nil || repeat < 1 {
+		return 1
+	}
+	return repeat
+}
+
+// reads a cell, consuming its contents up to the end element
+func odsCell(dec *xml.Decoder, el xml.StartElement) (CellValue, error) {
+	text, err := odsText(dec)
+	if err != nil {
+		return CellValue{}, err
+	}
+	cv := CellValue{Formatted: text, Formula: odsFormula(odsAttr(el, odsTableNS, "formula"))}
+	valueType := odsAttr(el, odsOfficeNS, "value-type")
+	switch valueType {
+	case "float", "percentage", "currency":
+		cv.Kind = CellNumber
+		cv.Value = odsAttr(el, odsOfficeNS, "value")
+	case "date":
+		t, err := parseODSDate(odsAttr(el, odsOfficeNS, "date-value"))
+		if err != nil {
+			return cv, err
+		}
+		cv.Kind = CellDate
+		cv.IsTime = true
+		cv.Time = t
+		cv.Value = strconv.FormatFloat(float64(t.Sub(excelEpoch))/float64(24*time.Hour), 'f', -1, 64)
+	case "time":
+		d, err := parseODSDuration(odsAttr(el, odsOfficeNS, "time-value"))
+		if err != nil {
+			return cv, err
+		}
+		cv.Kind = CellDate
+		cv.IsTime = true
+		cv.Time = excelEpoch.Add(d)
+		cv.Value = strconv.FormatFloat(float64(d)/float64(24*time.Hour), 'f', -1, 64)
+	case "boolean":
+		cv.Kind = CellBool
+		cv.Value = "0"
+		if odsAttr(el, odsOfficeNS, "boolean-value") == "true" {
+			cv.Value = "1"
+		}
+	case "string":
+		cv.Kind = CellString
+		cv.Value = text
+		if s := odsAttr(el, odsOfficeNS, "string-value"); s != "" {
+			cv.Value = s
+		}
+		// formulas which fail are saved as text, eg. #DIV/0! or Err:502
+		if odsAttr(el, odsTableNS, "formula") != "" && (strings.HasPrefix(text, "#") || strings.HasPrefix(text, "Err:")) {
+			cv.Kind = CellError
+		}
+	default:
+		// cells without a type hold no value, though may still have text
+		if text != "" {
+			cv.Kind = CellString
+			cv.Value = text
+		}
+	}
+	if cv.Value == "" {
+		return CellValue{Formula: cv.Formula}, nil
+	}
+	if cv.Formatted == "" {
+		cv.Formatted = cv.Value
+	}
+	return cv, nil
+}
+
+// the text of a cell: its paragraphs separated by newlines.  Annotations (comments) are left out
+func odsText(dec *xml.Decoder) (string, error) {
+	var sb strings.Builder
+	depth := 0
+	paragraphs := 0
+	annotation := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case annotation > 0 || (t.Name.Space == odsOfficeNS && t.Name.Local == "annotation"):
+				annotation++
+			case t.Name.Space != odsTextNS:
+			case t.Name.Local == "p":
+				if paragraphs > 0 {
+					sb.WriteByte('\n')
+				}
+				paragraphs++
+			case t.Name.Local == "s":
+				spaces, err := strconv.Atoi(odsAttr(t, odsTextNS, "c"))
+				if err != nil || spaces < 1 {
+					spaces = 1
+				}
+				sb.WriteString(strings.Repeat(" ", spaces))
+			case t.Name.Local == "tab":
+				sb.WriteByte('\t')
+			case t.Name.Local == "line-break":
+				sb.WriteByte('\n')
+			}
+		case xml.EndElement:
+			if depth == 0 {
+				return sb.String(), nil
+			}
+			depth--
+			if annotation > 0 {
+				annotation--
+			}
+		case xml.CharData:
+			if annotation == 0 && depth > 0 {
+				sb.Write(t)
+			}
+		}
+	}
+}
+
+// converts an OpenFormula formula, eg. of:=SUM([.A1:.A3];[$Sheet2.B1]), to the form used by excel,
+// eg. SUM(A1:A3,Sheet2!B1)
+func odsFormula(formula string) string {
+	if formula == "" {
+		return ""
+	}
+	if i := strings.Index(formula, ":="); i >= 0 && !strings.ContainsAny(formula[:i], "\"[(") {
+		formula = formula[i+2:]
+	}
+	formula = strings.TrimPrefix(formula, "=")
+	var sb strings.Builder
+	inString := false
+	inRef := false
+	for i := 0; i < len(formula); i++ {
+		ch := formula[i]
+		switch {
+		case ch == '"':
+			inString = !inString
+		case inString:
+		case ch == '[':
+			inRef = true
+			continue
+		case ch == ']':
+			inRef = false
+			continue
+		case inRef && ch == '$':
+			continue
+		case inRef && ch == '.':
+			// .A1 is on the same sheet, Sheet2.A1 on another
+			if i > 0 && formula[i-1] != '[' && formula[i-1] != ':' {
+				sb.WriteByte('!')
+			}
+			continue
+		case ch == ';':
+			ch = ','
+		}
+		sb.WriteByte(ch)
+	}
+	return sb.String()
+}
+
+// parses the date-value of a cell, eg. 2019-03-07 or 2019-03-07T13:12:59
+func parseODSDate(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02T15:04:05.999999999", "2006-01-02", time.RFC3339Nano} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid date value: " + s)
+}
+
+// parses the time-value of a cell, an ISO 8601 duration such as PT13H12M59S
+func parseODSDuration(s string) (time.Duration, error) {
+	invalid := errors.New("invalid time value: " + s)
+	rest := s
+	negative := strings.HasPrefix(rest, "-")
+	rest = strings.TrimPrefix(rest, "-")
+	if !strings.HasPrefix(rest, "P") {
+		return 0, invalid
+	}
+	rest = rest[1:]
+	units := map[byte]time.Duration{'D': 24 * time.Hour, 'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+	var d time.Duration
+	inTime := false
+	for rest != "" {
+		if rest[0] == 'T' {
+			inTime = true
+			rest = rest[1:]
+			continue
+		}
+		end := strings.IndexAny(rest, "DHMS")
+		if end < 1 {
+			return 0, invalid
+		}
+		n, err := strconv.ParseFloat(rest[:end], 64)
+		if err != nil || (rest[end] == 'D') == inTime {
+			return 0, invalid
+		}
+		d += time.Duration(n * float64(units[rest[end]]))
+		rest = rest[end+1:]
+	}
+	if negative {
+		d = -d
+	}
+	return d, nil
+}
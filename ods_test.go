@@ -0,0 +1,123 @@
+package excel_to_gorm
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const odsTestContent = `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0">
+<office:body><office:spreadsheet>
+<table:table table:name="Yield">
+<table:table-header-rows>
+<table:table-row><table:table-cell office:value-type="string"><text:p>Name</text:p></table:table-cell><table:table-cell office:value-type="float" office:value="2019"><text:p>2019</text:p></table:table-cell><table:table-cell office:value-type="float" office:value="2020"><text:p>2020</text:p></table:table-cell><table:table-cell table:number-columns-repeated="1021"/></table:table-row>
+</table:table-header-rows>
+<table:table-row table:number-rows-repeated="2"><table:table-cell office:value-type="string"><text:p>Honey<text:s text:c="2"/>crisp</text:p></table:table-cell><table:table-cell office:value-type="percentage" office:value="0.5"><text:p>50%</text:p></table:table-cell><table:table-cell office:value-type="currency" office:currency="EUR" office:value="1234.5"><text:p>1.234,50 €</text:p></table:table-cell></table:table-row>
+<table:table-row table:number-rows-repeated="1048573"><table:table-cell table:number-columns-repeated="1024"/></table:table-row>
+</table:table>
+<table:table table:name="Types">
+<table:table-row><table:table-cell office:value-type="date" office:date-value="2019-03-07T13:12:59"><text:p>07/03/19</text:p></table:table-cell><table:table-cell table:number-columns-repeated="2" office:value-type="boolean" office:boolean-value="true"><text:p>TRUE</text:p></table:table-cell><table:table-cell office:value-type="time" office:time-value="PT13H12M59S"><text:p>13:12:59</text:p></table:table-cell><table:table-cell table:formula="of:=1/0" office:value-type="string" office:string-value=""><text:p>#DIV/0!</text:p></table:table-cell></table:table-row>
+</table:table>
+</office:spreadsheet></office:body></office:document-content>`
+
+// an .ods file holding content.xml
+func mkODS(t *testing.T, content string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("application/vnd.oasis.opendocument.spreadsheet"))
+	w, err = zw.Create("content.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(content))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type odsYield struct {
+	Name  string  `xtg:"col:Name"`
+	Year  int     `xtg:"intcols:colname"`
+	Yield float64 `xtg:"intcols:value"`
+	Cell  string  `xtg:"meta:cell"`
+}
+
+func TestODS(t *testing.T) {
+	data := mkODS(t, odsTestContent)
+	wb, err := OpenReader(bytes.NewReader(data), int64(len(data)), "yield.ods")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wb.Close()
+	if got := wb.SheetNames(); len(got) != 2 || got[0] != "Yield" || got[1] != "Types" {
+		t.Errorf("SheetNames got %v", got)
+	}
+	// repeated empty rows and columns at the end are not counted
+	if rows, cols, err := wb.Dimensions("Yield"); err != nil || rows != 3 || cols != 3 {
+		t.Errorf("Dimensions got %v rows, %v cols, %v", rows, cols, err)
+	}
+
+	out, err := wb.ToSlice("Yield", &odsYield{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]odsYield)
+	want := []odsYield{
+		{"Honey  crisp", 2019, 0.5, "B2"}, {"Honey  crisp", 2020, 1234.5, "C2"},
+		{"Honey  crisp", 2019, 0.5, "B3"}, {"Honey  crisp", 2020, 1234.5, "C3"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("record %v: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+type odsTypes struct {
+	Date    time.Time `xtg:"colref:A"`
+	True    bool      `xtg:"colref:B"`
+	Repeat  bool      `xtg:"colref:C"`
+	Time    string    `xtg:"colref:D"`
+	Error   string    `xtg:"colref:E"`
+	Formula string    `xtg:"meta:formula"`
+}
+
+func TestODSCellTypes(t *testing.T) {
+	out, err := BytesToSlice(mkODS(t, odsTestContent), "Types", &odsTypes{}, Params{FirstRowHasData: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]odsTypes)
+	if len(got) != 1 {
+		t.Fatalf("got %+v", got)
+	}
+	if !got[0].Date.Equal(time.Date(2019, 3, 7, 13, 12, 59, 0, time.UTC)) || !got[0].True || !got[0].Repeat || got[0].Error != "#DIV/0!" {
+		t.Errorf("got %+v", got[0])
+	}
+}
+
+func TestODSFileSheetsToSlice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "yield.ods")
+	if err := os.WriteFile(path, mkODS(t, odsTestContent), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ExcelFileSheetsToSlice(path, "^Y", &odsYield{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(out.([]odsYield)); n != 4 {
+		t.Errorf("got %v records, want 4", n)
+	}
+}
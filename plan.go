@@ -0,0 +1,779 @@
+package excel_to_gorm
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c4rnot/csv_to_gorm"
+	"github.com/tealeg/xlsx/v3"
+	"gorm.io/gorm/schema"
+)
+
+// ModelPlan is the compiled description of how records of a model are filled from a sheet.
+// It is built once per model type from the xtg and gorm tags and cached, so that the tags
+// are not parsed again for every record
+type ModelPlan struct {
+	Type       reflect.Type
+	Fields     []FieldPlan
+	HasIntCols bool
+	HasMelt    bool
+	Ignore     []string // headings never melted
+	Children   []int    // indices in Fields of children fields
+}
+
+// FieldPlan describes a field of the model, including fields promoted from embedded structs
+type FieldPlan struct {
+	Name    string
+	DBName  string // column in the database, as named by gorm's default naming strategy.  See modelFields
+	Type    reflect.Type
+	Index   []int // as gorm's schema.Field.StructField.Index. Negative indices are pointers to embedded structs
+	Tag     Tag
+	source  fieldSource
+	convert converter // nil if the type cannot be read from a cell
+}
+
+// where the value of a field comes from
+type fieldSource int
+
+const (
+	srcNone fieldSource = iota
+	srcColumn
+	srcConst
+	srcIntColsHead
+	srcIntColsValue
+	srcMeltHead
+	srcMeltValue
+	srcMeta
+	srcChildren
+	srcDerived
+)
+
+// a column of the sheet along with its heading
+type colHeading struct {
+	col     int // 0 based
+	heading string
+}
+
+// a ModelPlan with its columns resolved against the headings of a particular sheet
+type sheetPlan struct {
+	plan       *ModelPlan
+	sheetName  string
+	sources    []fieldSource      // per field. ColMap and friends override the source in the plan
+	cols       []int              // per field, 0 based column for srcColumn
+	consts     []reflect.Value    // per field, value for srcConst, and srcMeta which is the same for every record
+	lookups    []*fieldLookup     // per field, nil unless it has a lookup: tag
+	transforms [][]fieldTransform // per field, nil unless it has a transform: tag
+	defaults   []reflect.Value    // per field, the default: converted to the field.  Invalid if none
+	fallbacks  [][]int            // per field, 0 based columns of the fallback: headings
+	hdgRowNum  int                // 0 based row of the headings, if any
+	headings   map[string]int     // 1 based columns by heading, as read by expr: and Params.Derive
+	// fk: fields are resolved once all records are read.  nextRecord is the index of the record being built
+	fkPending  []fkPending
+	nextRecord int
+	// parents created for fk:<Model>.<Field>;create tags, so ImportSheet can record them for UndoImport
+	createdParents []createdRow
+	group          *rowGroups // set if records with children are grouped from the rows of the sheet
+	intCols        []colHeading
+	meltCols       []colHeading
+	firstCol       int // 0 based, the first column read by the plan. -1 if none
+	params         Params
+	csvParams      csv_to_gorm.Params
+}
+
+// cache of compiled plans keyed by reflect.Type
+var planCache sync.Map
+
+// PlanFor returns the compiled plan for a model, passed as a pointer eg. &Apple{}
+func PlanFor(model interface{}) (*ModelPlan, error) {
+	return planFor(reflect.ValueOf(model).Elem().Type())
+}
+
+func planFor(modelTyp reflect.Type) (*ModelPlan, error) {
+	if plan, ok := planCache.Load(modelTyp); ok {
+		return plan.(*ModelPlan), nil
+	}
+	plan, err := compilePlan(modelTyp)
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := planCache.LoadOrStore(modelTyp, plan)
+	return actual.(*ModelPlan), nil
+}
+
+// lists the fields of the model, flattening embedded structs, and parses the xtg and gorm tags of
+// each one.  Fields marked gorm:"-" are left out
+func compilePlan(modelTyp reflect.Type) (*ModelPlan, error) {
+	plan := &ModelPlan{Type: modelTyp}
+	for _, field := range modelFields(modelTyp, schema.NamingStrategy{}, "") {
+		tag, err := parseTag(field.StructField)
+		if err != nil {
+			return nil, err
+		}
+		parseGormTag(field, &tag)
+		if tag.SkipField {
+			continue
+		}
+		fp := FieldPlan{
+			Name:    field.Name,
+			DBName:  field.DBName,
+			Type:    field.FieldType,
+			Index:   field.StructField.Index,
+			Tag:     tag,
+			convert: converterFor(field.FieldType),
+		}
+		switch {
+		case tag.IsChildren:
+			if childStructType(field.FieldType) == nil {
+				return nil, fmt.Errorf("field %v is tagged children but is a %v rather than a slice of structs", field.Name, field.FieldType)
+			}
+			fp.source = srcChildren
+			plan.Children = append(plan.Children, len(plan.Fields))
+		case tag.Expr != "":
+			fp.source = srcDerived
+		case tag.IsMapConst:
+			fp.source = srcConst
+		case tag.IsMeta:
+			fp.source = srcMeta
+		case tag.IsIntColsHead:
+			fp.source = srcIntColsHead
+		case tag.IsIntColsValue:
+			fp.source = srcIntColsValue
+		case tag.IsMeltHead:
+			fp.source = srcMeltHead
+		case tag.IsMeltValue:
+			fp.source = srcMeltValue
+		case tag.HasColIdx, tag.HasColanme, tag.HasDBColumn:
+			fp.source = srcColumn
+		}
+		if tag.IsIntColsHead || tag.IsIntColsValue {
+			plan.HasIntCols = true
+		}
+		if tag.IsMeltHead || tag.IsMeltValue {
+			plan.HasMelt = true
+		}
+		plan.Ignore = append(plan.Ignore, tag.Ignore...)
+		plan.Fields = append(plan.Fields, fp)
+	}
+	return plan, nil
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	valuerType  = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+)
+
+// the exported fields of a struct as gorm's schema parser lists them: anonymous and gorm:"embedded"
+// structs are flattened, with any embeddedPrefix, and DBName follows gorm's default naming.  Unlike
+// schema.Parse, it does not need the relations of the model to be valid, so any struct can be read.
+// Plans are cached per type rather than per DB, so DBName ignores the NamingStrategy of a gorm.Config.
+// Only gorm:"column:<name>" names are matched with headings, which no naming strategy changes, and
+// ImportSheet takes the columns it writes from the schema parsed with its DB
+func modelFields(typ reflect.Type, namer schema.Namer, prefix string) []*schema.Field {
+	var fields []*schema.Field
+	for i := 0; i < typ.NumField(); i++ {
+		structField := typ.Field(i)
+		if structField.PkgPath != "" {
+			continue
+		}
+		settings := schema.ParseTagSetting(structField.Tag.Get("gorm"), ";")
+		fieldType := structField.Type
+		indirect := fieldType
+		if indirect.Kind() == reflect.Ptr {
+			indirect = indirect.Elem()
+		}
+		_, embedded := settings["EMBEDDED"]
+		if (structField.Anonymous || embedded) && isEmbeddable(indirect) {
+			// gorm marks embedded pointers with negative indices, which fieldByIndex allocates
+			outer := structField.Index[0]
+			if fieldType.Kind() == reflect.Ptr {
+				outer = -outer - 1
+			}
+			for _, inner := range modelFields(indirect, namer, prefix+settings["EMBEDDEDPREFIX"]) {
+				inner.StructField.Index = append([]int{outer}, inner.StructField.Index...)
+				fields = append(fields, inner)
+			}
+			continue
+		}
+		field := &schema.Field{
+			Name:        structField.Name,
+			StructField: structField,
+			FieldType:   fieldType,
+			TagSettings: settings,
+		}
+		field.DefaultValue, field.HasDefaultValue = settings["DEFAULT"]
+		field.DBName = settings["COLUMN"]
+		if field.DBName == "" {
+			field.DBName = namer.ColumnName("", structField.Name)
+		}
+		field.DBName = prefix + field.DBName
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// whether gorm would flatten a struct embedded in a model, rather than store it as a value such as a time
+func isEmbeddable(typ reflect.Type) bool {
+	if typ.Kind() != reflect.Struct || typ == timeType {
+		return false
+	}
+	return !typ.Implements(valuerType) && !reflect.PtrTo(typ).Implements(valuerType) && !reflect.PtrTo(typ).Implements(scannerType)
+}
+
+// works out which fields are read from a fixed column rather than one found by heading.
+// returns a map of fieldnames to column numbers (starting at 1). ColRefMap takes precedence over
+// ColMap, which takes precedence over colref: and colidx: tags
+func (plan *ModelPlan) fixedColumns(params Params) (map[string]int, error) {
+	fixedCols := make(map[string]int)
+	for _, fp := range plan.Fields {
+		if fp.Tag.HasColIdx {
+			fixedCols[fp.Name] = fp.Tag.ColIdx
+		}
+	}
+	for fldName, colNo := range params.ColMap {
+		if colNo > 0 {
+			fixedCols[fldName] = colNo
+		}
+	}
+	for fldName, letters := range params.ColRefMap {
+		colNo, err := colLettersToNumber(letters)
+		if err != nil {
+			return fixedCols, fmt.Errorf("invalid ColRefMap entry for field: %v. %w", fldName, err)
+		}
+		fixedCols[fldName] = colNo
+	}
+	return fixedCols, nil
+}
+
+// resolves the columns of the plan against a sheet.  hdgRow is nil if the sheet has no heading row
+func (plan *ModelPlan) bind(src SheetSource, hdgRow []CellValue, params Params, csvParams csv_to_gorm.Params) (*sheetPlan, error) {
+	sheetName := src.Name()
+	sp := &sheetPlan{
+		plan:       plan,
+		sheetName:  sheetName,
+		sources:    make([]fieldSource, len(plan.Fields)),
+		cols:       make([]int, len(plan.Fields)),
+		firstCol:   -1,
+		consts:     make([]reflect.Value, len(plan.Fields)),
+		lookups:    make([]*fieldLookup, len(plan.Fields)),
+		transforms: make([][]fieldTransform, len(plan.Fields)),
+		defaults:   make([]reflect.Value, len(plan.Fields)),
+		fallbacks:  make([][]int, len(plan.Fields)),
+		params:     params,
+		csvParams:  csvParams,
+	}
+
+	fixedCols, err := plan.fixedColumns(params)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := lookupNumberLocale(params.Locale); err != nil {
+		return nil, fmt.Errorf("invalid Params.Locale. %w", err)
+	}
+
+	// map of column headings to 1 based column numbers (for consistency with csv_to_gorm)
+	var lclColMap map[string]int
+	var intColHdgs []string
+	if hdgRow != nil {
+		lclColMap = mapHeadingToCol(hdgRow)
+		intColHdgs = getIntCols(hdgRow)
+	}
+
+	var definedCols []string
+	for fldIx, fp := range plan.Fields {
+		source := fp.source
+		if fixedCols[fp.Name] > 0 {
+			source = srcColumn
+		}
+		if params.Derive[fp.Name] != nil {
+			source = srcDerived
+		}
+		switch source {
+		case srcColumn:
+			colNo := fixedCols[fp.Name]
+			switch {
+			case colNo > 0:
+				if colNo > src.MaxCol() {
+					return nil, fmt.Errorf("column %v supplied for field %v is out of range for sheet: %v", colNo, fp.Name, sheetName)
+				}
+			case fp.Tag.HasColanme:
+				if hdgRow == nil {
+					return nil, errors.New("field " + fp.Name + " is mapped to heading " + fp.Tag.Colname + " but sheet " + sheetName + " has no heading row. use colref: or colidx: instead")
+				}
+				colNo = lclColMap[fp.Tag.Colname]
+				if colNo == 0 {
+					return nil, errors.New("Could not find column header " + fp.Tag.Colname + " in sheet: " + sheetName)
+				}
+				definedCols = append(definedCols, fp.Tag.Colname)
+			case fp.Tag.HasDBColumn:
+				// unlike col:, a gorm column name is not required to be in the sheet
+				colNo = lclColMap[fp.Tag.DBColumn]
+				if colNo == 0 {
+					source = srcNone
+				}
+				definedCols = append(definedCols, fp.Tag.DBColumn)
+			}
+			if source == srcColumn && fp.convert == nil {
+				return nil, fmt.Errorf("field %v is read from a column, but its type %v is not supported", fp.Name, fp.Type)
+			}
+			sp.cols[fldIx] = colNo - 1
+			if source == srcColumn && (sp.firstCol < 0 || colNo-1 < sp.firstCol) {
+				sp.firstCol = colNo - 1
+			}
+		case srcConst:
+			constString := params.ConstMap[fp.Tag.ConstMapKey]
+			// trying to convert empty strings to numbers in csv_to_gorm will bomb!
+			if constString == "" && fp.Type.Name() != "string" {
+				return nil, fmt.Errorf("tag constant: " + fp.Tag.ConstMapKey + " missing for sheet:  " + sheetName + ". ")
+			}
+			sp.consts[fldIx] = csv_to_gorm.StringToType(constString, fp.Type, csvParams)
+		case srcMeta:
+			switch fp.Tag.Meta {
+			case "row":
+				switch fp.Type.Kind() {
+				case reflect.String, reflect.Int, reflect.Int64, reflect.Int32, reflect.Uint, reflect.Uint64, reflect.Uint32:
+				default:
+					return nil, fmt.Errorf("field %v is tagged meta:row but is a %v rather than an integer or string", fp.Name, fp.Type)
+				}
+			case "run":
+				switch fp.Type.Kind() {
+				case reflect.Uint, reflect.Uint64, reflect.Uint32, reflect.Int, reflect.Int64, reflect.Int32:
+				default:
+					return nil, fmt.Errorf("field %v is tagged meta:run but is a %v rather than an integer", fp.Name, fp.Type)
+				}
+			default:
+				if fp.Type.Kind() != reflect.String {
+					return nil, fmt.Errorf("field %v is tagged meta:%v but is a %v rather than a string", fp.Name, fp.Tag.Meta, fp.Type)
+				}
+			}
+			switch fp.Tag.Meta {
+			case "sheet":
+				sp.consts[fldIx] = reflect.ValueOf(sheetName).Convert(fp.Type)
+			case "file":
+				sp.consts[fldIx] = reflect.ValueOf(params.FileName).Convert(fp.Type)
+			case "run":
+				sp.consts[fldIx] = reflect.ValueOf(params.ImportRunID).Convert(fp.Type)
+			}
+		case srcDerived:
+			if params.Derive[fp.Name] == nil {
+				if fp.convert == nil {
+					return nil, fmt.Errorf("field %v has an expr:, but its type %v is not supported", fp.Name, fp.Type)
+				}
+				if err := checkExpr(fp.Tag.Expr, lclColMap); err != nil {
+					return nil, fmt.Errorf("invalid expr: for field %v. %w", fp.Name, err)
+				}
+			}
+			sp.headings = lclColMap
+		case srcIntColsHead, srcIntColsValue, srcMeltHead, srcMeltValue:
+			if hdgRow == nil {
+				return nil, errors.New("field " + fp.Name + " uses intcols: or melt:, which need a heading row, but sheet " + sheetName + " has none")
+			}
+		}
+		sp.sources[fldIx] = source
+		if sp.lookups[fldIx], err = newFieldLookup(&plan.Fields[fldIx], params); err != nil {
+			return nil, err
+		}
+		if sp.transforms[fldIx], err = newFieldTransforms(&plan.Fields[fldIx]); err != nil {
+			return nil, err
+		}
+		// the default of a fk: field is text to look up rather than a value of the field
+		if fp.Tag.HasDefault && fp.Tag.ForeignKey == nil {
+			if sp.defaults[fldIx], err = sp.convertDefault(&plan.Fields[fldIx]); err != nil {
+				return nil, err
+			}
+		}
+		for _, hdg := range fp.Tag.Fallbacks {
+			colNo := lclColMap[hdg]
+			if colNo == 0 {
+				return nil, errors.New("Could not find fallback column header " + hdg + " of field " + fp.Name + " in sheet: " + sheetName)
+			}
+			sp.fallbacks[fldIx] = append(sp.fallbacks[fldIx], colNo-1)
+			definedCols = append(definedCols, hdg)
+		}
+		if fp.Tag.ForeignKey != nil && params.DB == nil {
+			return nil, errors.New("field " + fp.Name + " has a fk: tag, which needs Params.DB")
+		}
+	}
+
+	if plan.HasIntCols {
+		for _, hdg := range intColHdgs {
+			sp.intCols = append(sp.intCols, colHeading{col: lclColMap[hdg] - 1, heading: hdg})
+		}
+	}
+	if plan.HasMelt {
+		for _, hdg := range getMeltCols(hdgRow, fixedCols, definedCols, plan.Ignore, plan.HasIntCols, intColHdgs) {
+			sp.meltCols = append(sp.meltCols, colHeading{col: lclColMap[hdg] - 1, heading: hdg})
+		}
+	}
+	if err := sp.bindChildren(src, hdgRow); err != nil {
+		return nil, err
+	}
+	return sp, nil
+}
+
+// whether the sheet fills the field at index, as gorm's schema.Field.StructField.Index
+func (sp *sheetPlan) fills(index []int) bool {
+	for fldIx, fp := range sp.plan.Fields {
+		if sp.sources[fldIx] != srcNone && sp.sources[fldIx] != srcChildren && reflect.DeepEqual(fp.Index, index) {
+			return true
+		}
+	}
+	return false
+}
+
+// the number of records generated by each row of the sheet
+func (sp *sheetPlan) recordsPerRow() int {
+	n := 1
+	if sp.plan.HasIntCols {
+		n *= len(sp.intCols)
+	}
+	if sp.plan.HasMelt {
+		n *= len(sp.meltCols)
+	}
+	return n
+}
+
+// appends the records generated by a row of the sheet: one per int column and melt column,
+// or just one if the model uses neither
+func (sp *sheetPlan) appendRecords(objSlice reflect.Value, src SheetSource, row int) (reflect.Value, error) {
+	if sp.group != nil {
+		return sp.appendGrouped(objSlice, src, row)
+	}
+	intCols := []colHeading{{}}
+	if sp.plan.HasIntCols {
+		intCols = sp.intCols
+	}
+	meltCols := []colHeading{{}}
+	if sp.plan.HasMelt {
+		meltCols = sp.meltCols
+	}
+	for _, intCol := range intCols {
+		for _, meltCol := range meltCols {
+			sp.nextRecord = objSlice.Len()
+			pending := len(sp.fkPending)
+			record, err := sp.buildRecord(src, row, intCol, meltCol)
+			if err == errSkipRecord {
+				sp.fkPending = sp.fkPending[:pending]
+				continue
+			}
+			if err != nil {
+				return objSlice, err
+			}
+			// add the record to the slice of records
+			objSlice = reflect.Append(objSlice, record)
+		}
+	}
+	return objSlice, nil
+}
+
+// creates a record from a row of the sheet for a given int column and melt column
+func (sp *sheetPlan) buildRecord(src SheetSource, row int, intCol colHeading, meltCol colHeading) (reflect.Value, error) {
+	// create the new item to add to the database
+	record := reflect.New(sp.plan.Type).Elem()
+
+	for fldIx := range sp.plan.Fields {
+		fp := &sp.plan.Fields[fldIx]
+		var value reflect.Value
+		var err error
+		switch sp.sources[fldIx] {
+		case srcColumn:
+			value, err = sp.fieldValue(src, row, sp.cols[fldIx], fldIx)
+		case srcConst:
+			value = sp.consts[fldIx]
+		case srcMeta:
+			value = sp.metaValue(fldIx, src, row, intCol, meltCol)
+		case srcIntColsHead:
+			value, err = sp.headingValue(intCol, fldIx)
+		case srcIntColsValue:
+			value, err = sp.fieldValue(src, row, intCol.col, fldIx)
+		case srcMeltHead:
+			value, err = sp.headingValue(meltCol, fldIx)
+		case srcMeltValue:
+			value, err = sp.fieldValue(src, row, meltCol.col, fldIx)
+		case srcDerived:
+			value, err = sp.derivedValue(src, row, fldIx)
+		default:
+			continue
+		}
+		if err == errSkipRecord {
+			return record, err
+		}
+		if err != nil {
+			return record, fmt.Errorf("sheet: %v row: %v field: %v. %w", sp.sheetName, row+1, fp.Name, err)
+		}
+		fieldByIndex(record, fp.Index).Set(value)
+	}
+	return record, nil
+}
+
+// the value of a field read from a melt or int column heading, passed through its transform: and lookup:
+func (sp *sheetPlan) headingValue(hdg colHeading, fldIx int) (reflect.Value, error) {
+	fp := &sp.plan.Fields[fldIx]
+	heading := hdg.heading
+	if sp.transforms[fldIx] != nil {
+		var err error
+		if heading, err = sp.transformText(heading, fldIx); err != nil {
+			return reflect.Zero(fp.Type), err
+		}
+	}
+	if sp.lookups[fldIx] != nil {
+		return sp.lookupHeading(heading, hdg.col, fldIx)
+	}
+	return csv_to_gorm.StringToType(heading, fp.Type, sp.csvParams), nil
+}
+
+// the value of a meta: field for a record
+func (sp *sheetPlan) metaValue(fldIx int, src SheetSource, row int, intCol colHeading, meltCol colHeading) reflect.Value {
+	fp := &sp.plan.Fields[fldIx]
+	if sp.consts[fldIx].IsValid() {
+		return sp.consts[fldIx]
+	}
+
+	// the column the record was created for
+	valueCol := colHeading{col: -1}
+	if sp.plan.HasMelt {
+		valueCol = meltCol
+	} else if sp.plan.HasIntCols {
+		valueCol = intCol
+	}
+
+	var meta string
+	switch fp.Tag.Meta {
+	case "row":
+		meta = strconv.Itoa(row + 1)
+	case "col":
+		meta = valueCol.heading
+	case "colref":
+		if valueCol.col >= 0 {
+			meta = xlsx.ColIndexToLetters(valueCol.col)
+		}
+	case "cell":
+		if col := sp.recordCol(valueCol); col >= 0 {
+			meta = xlsx.GetCellIDStringFromCoords(col, row)
+		}
+	case "formula":
+		if col := sp.recordCol(valueCol); col >= 0 {
+			meta = src.Cell(row, col).Formula
+		}
+	}
+	return csv_to_gorm.StringToType(meta, fp.Type, sp.csvParams)
+}
+
+// the column of the cell a record is identified by: the melt or int column it was created for,
+// otherwise the first column read.  -1 if none
+func (sp *sheetPlan) recordCol(valueCol colHeading) int {
+	if valueCol.col >= 0 {
+		return valueCol.col
+	}
+	return sp.firstCol
+}
+
+// converts a cell to the type of the field, using the default value from the tag if the cell is empty
+func (sp *sheetPlan) fieldValue(src SheetSource, row int, col int, fldIx int) (reflect.Value, error) {
+	cv := sp.readCell(src, row, col, fldIx)
+	// an empty cell is read from the fallback: columns of the field in turn
+	for _, fallback := range sp.fallbacks[fldIx] {
+		if strings.TrimSpace(cv.Value) != "" {
+			break
+		}
+		col = fallback
+		cv = sp.readCell(src, row, col, fldIx)
+	}
+	return sp.cellFieldValue(cv, row, col, fldIx)
+}
+
+// a cell read by a field, with the result of its formula if it has no saved one
+func (sp *sheetPlan) readCell(src SheetSource, row int, col int, fldIx int) CellValue {
+	cv := src.Cell(row, col)
+	if cv.Kind == CellEmpty && cv.Formula != "" {
+		cv = sp.formulaResult(src, row, col, cv, &sp.plan.Fields[fldIx])
+	}
+	return cv
+}
+
+// the value of a field converted from a cell, or the result of an expr:, which is reported against row and col
+func (sp *sheetPlan) cellFieldValue(cv CellValue, row int, col int, fldIx int) (reflect.Value, error) {
+	fp := &sp.plan.Fields[fldIx]
+	if cv.Kind == CellError {
+		return sp.errorValue(cv, row, col, fp)
+	}
+	if sp.transforms[fldIx] != nil && cv.Kind != CellEmpty {
+		var err error
+		if cv, err = sp.transformCell(cv, fldIx); err != nil {
+			return reflect.Zero(fp.Type), err
+		}
+	}
+	if sp.lookups[fldIx] != nil {
+		mapped, ok, err := sp.lookupCell(cv, row, col, fldIx)
+		if err != nil || !ok {
+			return reflect.Zero(fp.Type), err
+		}
+		cv = mapped
+	}
+	if fp.Tag.ForeignKey != nil {
+		text := strings.TrimSpace(cv.Value)
+		if text == "" && fp.Tag.HasDefault && !fp.Tag.IsGormDefault {
+			text = fp.Tag.Default
+		}
+		if text != "" {
+			sp.fkPending = append(sp.fkPending, fkPending{record: sp.nextRecord, fldIx: fldIx, text: text, row: row, col: col})
+		}
+		return reflect.Zero(fp.Type), nil
+	}
+	if dflt := sp.defaults[fldIx]; dflt.IsValid() && strings.TrimSpace(cv.Value) == "" {
+		// each record gets its own copy of a pointer
+		if dflt.Kind() == reflect.Ptr && !dflt.IsNil() {
+			ptr := reflect.New(dflt.Type().Elem())
+			ptr.Elem().Set(dflt.Elem())
+			return ptr, nil
+		}
+		return dflt, nil
+	}
+	if fp.Tag.overridesParams() {
+		return fp.convert(cv, fp.Type, sp.fieldParams(fp))
+	}
+	return fp.convert(cv, fp.Type, sp.params)
+}
+
+// the default of a field converted like the text of a cell.  gorm defaults which do not convert, eg.
+// CURRENT_TIMESTAMP, are left to the database
+func (sp *sheetPlan) convertDefault(fp *FieldPlan) (reflect.Value, error) {
+	err := fmt.Errorf("its type %v is not supported", fp.Type)
+	var value reflect.Value
+	if fp.convert != nil {
+		params := sp.params
+		if fp.Tag.overridesParams() {
+			params = sp.fieldParams(fp)
+		}
+		value, err = fp.convert(csvCellValue(fp.Tag.Default), fp.Type, params)
+	}
+	switch {
+	case err != nil && fp.Tag.IsGormDefault:
+		return reflect.Value{}, nil
+	case err != nil:
+		return value, fmt.Errorf("invalid default %v for field %v. %w", fp.Tag.Default, fp.Name, err)
+	}
+	return value, nil
+}
+
+// the params of the sheet with the overrides of a field's tag
+func (sp *sheetPlan) fieldParams(fp *FieldPlan) Params {
+	params := sp.params
+	if fp.Tag.NumLocale != "" {
+		params.Locale = fp.Tag.NumLocale
+	}
+	if fp.Tag.TimeLayouts != nil {
+		params.DateLayouts = fp.Tag.TimeLayouts
+	}
+	if fp.Tag.TrueValues != nil {
+		params.TrueValues = fp.Tag.TrueValues
+	}
+	if fp.Tag.FalseValues != nil {
+		params.FalseValues = fp.Tag.FalseValues
+	}
+	if fp.Tag.HasBlankBool {
+		params.BlankBool = fp.Tag.BlankBool
+	}
+	return params
+}
+
+// whether the tag overrides any Params used to convert cells
+func (tag *Tag) overridesParams() bool {
+	return tag.NumLocale != "" || tag.TimeLayouts != nil || tag.TrueValues != nil || tag.FalseValues != nil || tag.HasBlankBool
+}
+
+// returned by fieldValue when the record should be left out
+var errSkipRecord = errors.New("skip record")
+
+// the value of a field read from a cell holding an error such as #N/A, following the onerror: tag
+// of the field or Params.OnCellError.  The error is reported whatever the policy
+func (sp *sheetPlan) errorValue(cv CellValue, row int, col int, fp *FieldPlan) (reflect.Value, error) {
+	cell := xlsx.GetCellIDStringFromCoords(col, row)
+	message := "cell holds error " + cv.Value
+	if cv.Formula != "" {
+		message += " from formula =" + cv.Formula
+	}
+	sp.params.Report.Add(Issue{Sheet: sp.sheetName, Row: row + 1, Cell: cell, Field: fp.Name, Code: IssueCellError, Value: cv.Value, Message: message})
+
+	policy := sp.params.OnCellError
+	if fp.Tag.HasOnCellError {
+		policy = fp.Tag.OnCellError
+	}
+	valueType := fp.Type
+	if valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+	switch policy {
+	case OnErrorNull:
+		return reflect.Zero(fp.Type), nil
+	case OnErrorZero:
+		if fp.Type.Kind() == reflect.Ptr {
+			return reflect.New(valueType), nil
+		}
+		return reflect.Zero(fp.Type), nil
+	case OnErrorNaN:
+		if valueType.Kind() != reflect.Float32 && valueType.Kind() != reflect.Float64 {
+			return reflect.Zero(fp.Type), nil
+		}
+		value := reflect.New(valueType)
+		value.Elem().SetFloat(math.NaN())
+		if fp.Type.Kind() == reflect.Ptr {
+			return value, nil
+		}
+		return value.Elem(), nil
+	case OnErrorSkipRecord:
+		return reflect.Zero(fp.Type), errSkipRecord
+	case OnErrorFail:
+		return reflect.Zero(fp.Type), errors.New(message + " in cell " + cell)
+	}
+	return fp.convert(cv, fp.Type, sp.params)
+}
+
+// the value of a formula cell without a saved result: evaluated if Params.EvaluateFormulas is set,
+// otherwise empty.  Either way, a formula which cannot be evaluated is reported
+func (sp *sheetPlan) formulaResult(src SheetSource, row int, col int, cv CellValue, fp *FieldPlan) CellValue {
+	issue := Issue{Sheet: sp.sheetName, Row: row + 1, Cell: xlsx.GetCellIDStringFromCoords(col, row), Field: fp.Name}
+	if !sp.params.EvaluateFormulas {
+		issue.Code = IssueUncachedFormula
+		issue.Message = "formula =" + cv.Formula + " has no saved result"
+		sp.params.Report.Add(issue)
+		return cv
+	}
+	result, err := evaluateCell(src, row, col)
+	if err != nil {
+		issue.Code = IssueFormula
+		issue.Message = "could not evaluate =" + cv.Formula + ". " + err.Error()
+		sp.params.Report.Add(issue)
+		return cv
+	}
+	result.Formula = cv.Formula
+	result.IsTime = cv.IsTime && result.Kind == CellNumber
+	return result
+}
+
+// returns the (settable) field of a record given the index of a FieldPlan,
+// allocating any nil pointers to embedded structs on the way
+func fieldByIndex(record reflect.Value, index []int) reflect.Value {
+	v := record
+	for _, ix := range index {
+		if ix < 0 {
+			ix = -ix - 1
+			v = v.Field(ix)
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		} else {
+			v = v.Field(ix)
+		}
+	}
+	return v
+}
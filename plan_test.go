@@ -0,0 +1,135 @@
+package excel_to_gorm
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/tealeg/xlsx/v3"
+	"gorm.io/gorm"
+)
+
+type benchLoss struct {
+	gorm.Model
+	Name  string  `xtg:"col:Name"`
+	Cause string  `xtg:"melt:colname"`
+	Loss  float64 `xtg:"melt:value"`
+}
+
+// a melt sheet of 1000 rows by 50 columns, which makes 50000 records
+func benchMeltSheet(b *testing.B) *xlsx.Sheet {
+	f := xlsx.NewFile()
+	sh, err := f.AddSheet("s")
+	if err != nil {
+		b.Fatal(err)
+	}
+	hdg := sh.AddRow()
+	hdg.AddCell().SetString("Name")
+	for c := 0; c < 50; c++ {
+		hdg.AddCell().SetString(fmt.Sprintf("cause%d", c))
+	}
+	for r := 0; r < 1000; r++ {
+		row := sh.AddRow()
+		row.AddCell().SetString(fmt.Sprintf("n%d", r))
+		for c := 0; c < 50; c++ {
+			row.AddCell().SetFloat(float64(r * c))
+		}
+	}
+	var buf bytes.Buffer
+	if err := f.Write(&buf); err != nil {
+		b.Fatal(err)
+	}
+	wb, err := xlsx.OpenBinary(buf.Bytes())
+	if err != nil {
+		b.Fatal(err)
+	}
+	return wb.Sheet["s"]
+}
+
+func BenchmarkMeltLargeSheet(b *testing.B) {
+	sh := benchMeltSheet(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := WorksheetToSlice(sh, &benchLoss{}, Params{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// the cost per record of parsing the tags of the model, as was done for every record before plans were cached
+func BenchmarkCompilePlan(b *testing.B) {
+	typ := reflect.TypeOf(benchLoss{})
+	for i := 0; i < b.N; i++ {
+		if _, err := compilePlan(typ); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// the cost per record of looking up the cached plan instead
+func BenchmarkCachedPlan(b *testing.B) {
+	typ := reflect.TypeOf(benchLoss{})
+	for i := 0; i < b.N; i++ {
+		if _, err := planFor(typ); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestPlanIsCachedPerType(t *testing.T) {
+	typ := reflect.TypeOf(benchLoss{})
+	first, err := planFor(typ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := planFor(typ)
+	if first != second {
+		t.Error("expected the cached plan to be reused")
+	}
+	if len(first.Fields) == 0 {
+		t.Error("expected the plan to list the fields of the model")
+	}
+}
+
+type gormTagged struct {
+	gorm.Model
+	Name    string  `gorm:"column:apple_name"`
+	Secret  string  `xtg:"col:Secret" gorm:"-"`
+	Origin  string  `xtg:"col:Origin" gorm:"default:Unknown"`
+	Address address `gorm:"embedded;embeddedPrefix:addr_"`
+}
+
+type address struct {
+	Town string `gorm:"column:town"`
+}
+
+func TestGormTags(t *testing.T) {
+	rows := [][]string{{"apple_name", "Secret", "Origin", "addr_town"}, {"Gala", "x", "", "Leeds"}}
+	out, err := SourceToSlice(NewGridSource("s", rows), &gormTagged{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]gormTagged)[0]
+	if got.Name != "Gala" || got.Secret != "" || got.Origin != "Unknown" || got.Address.Town != "Leeds" {
+		t.Errorf("got %+v", got)
+	}
+}
+
+// a plain struct which gorm cannot use as a model is still read, as the baseline did
+type plainDTO struct {
+	Name   string             `xtg:"col:Name"`
+	Extra  struct{ A, B int } // untagged struct field
+	Labels map[string]string
+}
+
+func TestPlainStructModel(t *testing.T) {
+	rows := [][]string{{"Name"}, {"Gala"}}
+	out, err := SourceToSlice(NewGridSource("s", rows), &plainDTO{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.([]plainDTO); len(got) != 1 || got[0].Name != "Gala" {
+		t.Errorf("got %+v", got)
+	}
+}
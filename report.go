@@ -0,0 +1,66 @@
+package excel_to_gorm
+
+import (
+	"fmt"
+	"sync"
+)
+
+// codes of the issues recorded in a Report
+const (
+	IssueUncachedFormula = "uncached_formula" // a formula cell has no saved result and was not evaluated
+	IssueFormula         = "formula"          // a formula could not be evaluated
+	IssueCellError       = "cell_error"       // a cell holds an error value such as #N/A.  Issue.Value is the error
+	IssueUnmapped        = "unmapped"         // the text of a cell is not in the lookup: of its field.  Issue.Value is the text
+	IssueDuplicateImport = "duplicate_import" // ImportSheet was given a file already imported.  Issue.Value is the ID of the earlier run
+)
+
+// Issue is a problem found with a cell while converting a sheet
+type Issue struct {
+	Sheet   string
+	Row     int    // starting at 1
+	Cell    string // eg. "B3"
+	Field   string
+	Code    string
+	Value   string // the value of the cell, if relevant
+	Message string
+}
+
+func (issue Issue) String() string {
+	return fmt.Sprintf("sheet: %v cell: %v field: %v. %v: %v", issue.Sheet, issue.Cell, issue.Field, issue.Code, issue.Message)
+}
+
+// Report collects the issues found while converting sheets.  Pass the same Report in Params to
+// conversions of several sheets, including concurrent ones, to collect them all
+type Report struct {
+	mu     sync.Mutex
+	issues []Issue
+	// a cell read for several records, eg. by melt:, is only reported once per field
+	seen map[Issue]bool
+}
+
+// Add records an issue.  Does nothing to a nil Report
+func (r *Report) Add(issue Issue) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.seen == nil {
+		r.seen = make(map[Issue]bool)
+	}
+	if r.seen[issue] {
+		return
+	}
+	r.seen[issue] = true
+	r.issues = append(r.issues, issue)
+}
+
+// Issues returns the issues recorded so far, in the order they were found
+func (r *Report) Issues() []Issue {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Issue(nil), r.issues...)
+}
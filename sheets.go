@@ -0,0 +1,215 @@
+package excel_to_gorm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/tealeg/xlsx/v3"
+)
+
+// SheetSpec describes how to convert one sheet of a workbook
+type SheetSpec struct {
+	Model  interface{} // pointer to the model to fill, eg. &Apple{}
+	Params Params
+}
+
+// SheetResult holds the records converted from one sheet, or the error which stopped the conversion
+type SheetResult struct {
+	Records interface{} // slice of the model, which needs to be typecast by the caller
+	Err     error
+}
+
+// state shared by all conversions of the same sheet
+type sheetGuard struct {
+	mu     sync.Mutex
+	closed bool
+}
+
+// *xlsx.Sheet -> *sheetGuard
+var sheetGuards sync.Map
+
+// xlsx.Sheet keeps track of its current row while it is read, so goroutines using the same sheet take turns.
+// Once a sheet is closed, later users get an error rather than a closed sheet
+func lockSheet(sh *xlsx.Sheet) (*sheetGuard, error) {
+	g, _ := sheetGuards.LoadOrStore(sh, &sheetGuard{})
+	guard := g.(*sheetGuard)
+	guard.mu.Lock()
+	if guard.closed {
+		guard.mu.Unlock()
+		return nil, errors.New("sheet: " + sh.Name + " has already been closed")
+	}
+	return guard, nil
+}
+
+// closes a sheet locked by lockSheet
+func (guard *sheetGuard) closeSheet(sh *xlsx.Sheet) {
+	guard.closed = true
+	sheetGuards.Delete(sh)
+	sh.Close()
+}
+
+// xlsx panics with this message when a sheet which was closed elsewhere is read
+const closedSheetMessage = "no cellstore"
+
+// whether a recovered panic is the one xlsx raises on reading a closed sheet
+func isClosedSheetPanic(r interface{}) bool {
+	msg, ok := r.(string)
+	return ok && strings.Contains(msg, closedSheetMessage)
+}
+
+// converts a sheet, taking turns with other goroutines using it.  WorkbookToSlice closes the sheet
+// afterwards (closeSheet), as it always has
+func convertSheet(sh *xlsx.Sheet, model interface{}, params Params, closeSheet bool) (result interface{}, err error) {
+	modelTyp := reflect.ValueOf(model).Elem().Type()
+	guard, err := lockSheet(sh)
+	if err != nil {
+		return reflect.Zero(reflect.SliceOf(modelTyp)).Interface(), err
+	}
+	defer guard.mu.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			if !isClosedSheetPanic(r) {
+				panic(r)
+			}
+			result = reflect.Zero(reflect.SliceOf(modelTyp)).Interface()
+			err = fmt.Errorf("could not read sheet: %v. %v", sh.Name, r)
+			guard.closed = true
+			sheetGuards.Delete(sh)
+			return
+		}
+		if closeSheet {
+			guard.closeSheet(sh)
+		}
+	}()
+	return WorksheetToSlice(sh, model, params)
+}
+
+// converts several sheets of a workbook at once, each with its own model and params.
+// params.Concurrency limits how many sheets are converted at the same time.
+// Each sheet is closed once converted, as with WorkbookToSlice.
+// Returns the result for every sheet in specs.  The error lists the sheets which failed, if any
+func WorkbookToSlices(wb *xlsx.File, specs map[string]SheetSpec, params Params) (map[string]SheetResult, error) {
+	return convertSheets(specs, params, func(sheetName string, spec SheetSpec) (interface{}, error) {
+		return WorkbookToSlice(wb, sheetName, spec.Model, spec.Params)
+	})
+}
+
+// calls convert for every sheet in specs, params.Concurrency at a time, and collects the results
+func convertSheets(specs map[string]SheetSpec, params Params, convert func(sheetName string, spec SheetSpec) (interface{}, error)) (map[string]SheetResult, error) {
+	results := make(map[string]SheetResult, len(specs))
+	if len(specs) == 0 {
+		return results, nil
+	}
+
+	workers := params.Concurrency
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(specs) {
+		workers = len(specs)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sheetNames := make(chan string)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sheetName := range sheetNames {
+				records, err := convert(sheetName, specs[sheetName])
+				mu.Lock()
+				results[sheetName] = SheetResult{Records: records, Err: err}
+				mu.Unlock()
+			}
+		}()
+	}
+	for sheetName := range specs {
+		sheetNames <- sheetName
+	}
+	close(sheetNames)
+	wg.Wait()
+
+	var failed []string
+	for sheetName, result := range results {
+		if result.Err != nil {
+			failed = append(failed, sheetName+": "+result.Err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return results, fmt.Errorf("%v of %v sheets failed. %v", len(failed), len(specs), strings.Join(failed, "; "))
+	}
+	return results, nil
+}
+
+// converts every sheet of the workbook whose name matches the regular expression pattern into one
+// slice of the model, in the order the sheets appear in the workbook.  Suits workbooks with one sheet
+// per region or period, all laid out the same.  Tag a field xtg:"sheetname" to record which sheet each
+// record came from.  The sheets are converted concurrently, see WorkbookToSlices
+func WorkbookSheetsToSlice(wb *xlsx.File, pattern string, model interface{}, params Params) (interface{}, error) {
+	var sheetNames []string
+	for _, sh := range wb.Sheets {
+		sheetNames = append(sheetNames, sh.Name)
+	}
+	return matchingSheetsToSlice(sheetNames, pattern, model, params, func(sheetName string, spec SheetSpec) (interface{}, error) {
+		return WorkbookToSlice(wb, sheetName, spec.Model, spec.Params)
+	})
+}
+
+// converts every sheet of the workbook whose name matches the regular expression pattern into one
+// slice of the model, as WorkbookSheetsToSlice.  The sheets stay open
+func (w *Workbook) SheetsToSlice(pattern string, model interface{}, params Params) (interface{}, error) {
+	return matchingSheetsToSlice(w.SheetNames(), pattern, model, params, func(sheetName string, spec SheetSpec) (interface{}, error) {
+		return w.ToSlice(sheetName, spec.Model, spec.Params)
+	})
+}
+
+// converts the sheets whose name matches pattern with convert and appends the records in sheet order
+func matchingSheetsToSlice(allSheets []string, pattern string, model interface{}, params Params, convert func(sheetName string, spec SheetSpec) (interface{}, error)) (interface{}, error) {
+	modelTyp := reflect.ValueOf(model).Elem().Type()
+	objSlice := reflect.Zero(reflect.SliceOf(modelTyp))
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return objSlice.Interface(), fmt.Errorf("invalid sheet name pattern: %v. %w", pattern, err)
+	}
+	var sheetNames []string
+	specs := make(map[string]SheetSpec)
+	for _, sheetName := range allSheets {
+		if re.MatchString(sheetName) {
+			sheetNames = append(sheetNames, sheetName)
+			specs[sheetName] = SheetSpec{Model: model, Params: params}
+		}
+	}
+	if len(sheetNames) == 0 {
+		return objSlice.Interface(), errors.New("no sheets match pattern: " + pattern)
+	}
+
+	results, err := convertSheets(specs, params, convert)
+	for _, sheetName := range sheetNames {
+		if results[sheetName].Err == nil {
+			objSlice = reflect.AppendSlice(objSlice, reflect.ValueOf(results[sheetName].Records))
+		}
+	}
+	return objSlice.Interface(), err
+}
+
+// opens the file and converts every sheet whose name matches the regular expression pattern.
+// Any format Open reads is supported.  see WorkbookSheetsToSlice
+func ExcelFileSheetsToSlice(fileName string, pattern string, model interface{}, params Params) (interface{}, error) {
+	wb, err := Open(fileName)
+	if err != nil {
+		modelTyp := reflect.ValueOf(model).Elem().Type()
+		return reflect.Zero(reflect.SliceOf(modelTyp)).Interface(), err
+	}
+	defer wb.Close()
+	return wb.SheetsToSlice(pattern, model, params)
+}
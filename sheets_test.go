@@ -0,0 +1,121 @@
+package excel_to_gorm
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type sheetApple struct {
+	Variety string `xtg:"col:Variety"`
+	Weight  int    `xtg:"col:Weight"`
+}
+
+func sheetAppleBook() map[string][][]interface{} {
+	return map[string][][]interface{}{
+		"north": {{"Variety", "Weight"}, {"Gala", 3}, {"Fuji", 4}},
+		"south": {{"Variety", "Weight"}, {"Braeburn", 5}},
+		"notes": {{"Note"}, {"ignore me"}},
+	}
+}
+
+func TestWorkbookToSlices(t *testing.T) {
+	wb := mkBook(t, sheetAppleBook())
+	results, err := WorkbookToSlices(wb, map[string]SheetSpec{
+		"north": {Model: &sheetApple{}},
+		"south": {Model: &sheetApple{}},
+	}, Params{Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(results["north"].Records.([]sheetApple)); n != 2 {
+		t.Errorf("north: got %v records, want 2", n)
+	}
+	if n := len(results["south"].Records.([]sheetApple)); n != 1 {
+		t.Errorf("south: got %v records, want 1", n)
+	}
+}
+
+func TestWorkbookToSlicesReportsFailedSheets(t *testing.T) {
+	wb := mkBook(t, sheetAppleBook())
+	results, err := WorkbookToSlices(wb, map[string]SheetSpec{
+		"north": {Model: &sheetApple{}},
+		"notes": {Model: &sheetApple{}},
+	}, Params{})
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 sheets failed") {
+		t.Fatalf("got error %v", err)
+	}
+	if results["north"].Err != nil || results["notes"].Err == nil {
+		t.Errorf("got north: %v, notes: %v", results["north"].Err, results["notes"].Err)
+	}
+}
+
+func TestConvertClosedSheet(t *testing.T) {
+	wb := mkBook(t, sheetAppleBook())
+	sh := wb.Sheet["north"]
+	sh.Close()
+	if _, err := convertSheet(sh, &sheetApple{}, Params{}, false); err == nil {
+		t.Error("expected an error reading a closed sheet")
+	}
+}
+
+func TestConvertSheetPassesOnOtherPanics(t *testing.T) {
+	wb := mkBook(t, sheetAppleBook())
+	params := Params{Derive: map[string]func(RowView) (interface{}, error){
+		"Weight": func(RowView) (interface{}, error) { panic("bug in callback") },
+	}}
+	defer func() {
+		if r := recover(); r != "bug in callback" {
+			t.Errorf("got panic %v", r)
+		}
+	}()
+	convertSheet(wb.Sheet["north"], &sheetApple{}, params, false)
+	t.Error("expected the panic to reach the caller")
+}
+
+type regionApple struct {
+	Region  string `xtg:"sheetname"`
+	Variety string `xtg:"col:Variety"`
+}
+
+func TestExcelFileSheetsToSlice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apples.xlsx")
+	if err := mkBook(t, sheetAppleBook()).Save(path); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ExcelFileSheetsToSlice(path, "^(north|south)$", &regionApple{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]regionApple)
+	if len(got) != 3 {
+		t.Fatalf("got %+v", got)
+	}
+	for _, rec := range got {
+		if rec.Region != "north" && rec.Region != "south" {
+			t.Errorf("unexpected region in %+v", rec)
+		}
+	}
+	if _, err := ExcelFileSheetsToSlice(path, "^east$", &regionApple{}, Params{}); err == nil {
+		t.Error("expected an error when no sheets match")
+	}
+}
+
+func TestWorkbookSheetsToSliceKeepsSheetsOpen(t *testing.T) {
+	data := mkBytes(t, sheetAppleBook())
+	wb, err := OpenReader(bytes.NewReader(data), int64(len(data)), "apples.xlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wb.Close()
+	for i := 0; i < 2; i++ {
+		out, err := wb.SheetsToSlice("th$", &regionApple{}, Params{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(out.([]regionApple)); n != 3 {
+			t.Errorf("pass %v: got %v records, want 3", i, n)
+		}
+	}
+}
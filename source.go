@@ -0,0 +1,376 @@
+package excel_to_gorm
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tealeg/xlsx/v3"
+)
+
+// CellKind is the kind of value held by a cell, whatever the format of the file it came from
+type CellKind int
+
+const (
+	CellEmpty CellKind = iota
+	CellString
+	CellNumber
+	CellBool
+	CellError
+	CellDate // a date or time the backend has already decoded into CellValue.Time
+)
+
+// CellValue is a cell of a sheet, independent of the format of the file it came from
+type CellValue struct {
+	Kind      CellKind
+	Value     string    // as stored, eg. "0.5" for a cell displayed as 50%.  Booleans are "1" or "0"
+	Formatted string    // as displayed, if known.  Use Display(), as .xlsx cells are only formatted when needed
+	IsTime    bool      // a number with a date or time format, holding an excel serial date
+	Time      time.Time // for CellDate
+	// the formula of the cell without the leading =, eg. "SUM(A1:A3)".  Not read from .xls files.
+	// A cell with a formula but no saved result is CellEmpty
+	Formula  string
+	xlsxCell *xlsx.Cell
+	// read from text, eg. a CSV file, so Kind is only a guess
+	fromText bool
+	// serial dates of the workbook count from 1904, as in workbooks made by old versions of excel for mac
+	date1904 bool
+}
+
+// MergedRange is a block of merged cells.  Rows and columns start at 0 and include the last
+type MergedRange struct {
+	FirstRow int
+	FirstCol int
+	LastRow  int
+	LastCol  int
+}
+
+// SheetSource is a sheet of any supported format, as read by the record builder.  Implement it to
+// feed other formats, or test models against in-memory data, through SourceToSlice
+type SheetSource interface {
+	Name() string
+	MaxCol() int
+	Rows() RowIterator
+	// the cell at a 0 based row and column.  Cells outside the data are empty.  Streaming sources,
+	// such as CSV, only return the cells of the current row
+	Cell(row, col int) CellValue
+	// the merged cells of the sheet, if the format has them
+	MergedCells() []MergedRange
+}
+
+// RowIterator iterates over the rows of a SheetSource, including empty ones
+type RowIterator interface {
+	Next() bool
+	Row() int // 0 based
+	Err() error
+}
+
+// implemented by sources which know how many rows they have before they are read
+type rowCounter interface {
+	MaxRow() int
+}
+
+// iterates over a known number of rows
+type countRows struct {
+	n   int
+	row int
+}
+
+func newCountRows(n int) *countRows {
+	return &countRows{n: n, row: -1}
+}
+
+func (it *countRows) Next() bool {
+	if it.row+1 >= it.n {
+		return false
+	}
+	it.row++
+	return true
+}
+
+func (it *countRows) Row() int {
+	return it.row
+}
+
+func (it *countRows) Err() error {
+	return nil
+}
+
+// NewXlsxSource adapts a sheet of an .xlsx workbook.  Like WorksheetToSlice, the sheet must not be
+// read by other goroutines while the source is in use
+func NewXlsxSource(sh *xlsx.Sheet) SheetSource {
+	return newXlsxSource(sh)
+}
+
+// a sheet of an .xlsx workbook.  The current row is cached, as xlsx.Sheet looks rows up one at a time
+type xlsxSource struct {
+	sh     *xlsx.Sheet
+	rowNum int
+	row    *xlsx.Row
+	merged []MergedRange
+	// whether merged has been filled in, as the whole sheet is read to find them
+	mergedRead bool
+}
+
+func newXlsxSource(sh *xlsx.Sheet) *xlsxSource {
+	return &xlsxSource{sh: sh, rowNum: -1}
+}
+
+func (s *xlsxSource) Name() string {
+	return s.sh.Name
+}
+
+func (s *xlsxSource) MaxCol() int {
+	return s.sh.MaxCol
+}
+
+func (s *xlsxSource) MaxRow() int {
+	return s.sh.MaxRow
+}
+
+func (s *xlsxSource) Rows() RowIterator {
+	return newCountRows(s.sh.MaxRow)
+}
+
+func (s *xlsxSource) Cell(row, col int) CellValue {
+	if row < 0 || row >= s.sh.MaxRow || col < 0 || col >= s.sh.MaxCol {
+		return CellValue{}
+	}
+	if s.row == nil || s.rowNum != row {
+		r, err := s.sh.Row(row)
+		if err != nil {
+			return CellValue{}
+		}
+		s.row, s.rowNum = r, row
+	}
+	return XlsxCellValue(s.row.GetCell(col))
+}
+
+func (s *xlsxSource) MergedCells() []MergedRange {
+	if s.mergedRead {
+		return s.merged
+	}
+	s.mergedRead = true
+	for row := 0; row < s.sh.MaxRow; row++ {
+		r, err := s.sh.Row(row)
+		if err != nil {
+			break
+		}
+		r.ForEachCell(func(c *xlsx.Cell) error {
+			if c.HMerge > 0 || c.VMerge > 0 {
+				col, _ := c.GetCoordinates()
+				s.merged = append(s.merged, MergedRange{FirstRow: row, FirstCol: col, LastRow: row + c.VMerge, LastCol: col + c.HMerge})
+			}
+			return nil
+		})
+	}
+	// the cached row may have been replaced while reading
+	s.row = nil
+	return s.merged
+}
+
+// XlsxCellValue converts a cell of an .xlsx sheet
+func XlsxCellValue(c *xlsx.Cell) CellValue {
+	cv := CellValue{Value: c.Value, Formula: c.Formula(), xlsxCell: c}
+	switch c.Type() {
+	case xlsx.CellTypeNumeric:
+		cv.Kind = CellNumber
+		cv.IsTime = c.IsTime()
+		cv.date1904 = c.Row != nil && c.Row.Sheet != nil && c.Row.Sheet.File != nil && c.Row.Sheet.File.Date1904
+	case xlsx.CellTypeBool:
+		cv.Kind = CellBool
+	case xlsx.CellTypeError:
+		cv.Kind = CellError
+	default:
+		cv.Kind = CellString
+	}
+	if cv.Value == "" {
+		cv.Kind = CellEmpty
+		// the format of an unsaved formula still says whether its result is a date
+		cv.IsTime = cv.Formula != "" && c.IsTime()
+	}
+	return cv
+}
+
+// NewGridSource adapts rows of text, eg. to test a model without building a workbook.
+// As with CSV, cells which parse as numbers are number cells and the rest are text
+func NewGridSource(name string, rows [][]string) SheetSource {
+	sh := &gridSheet{name: name, rows: make([][]CellValue, len(rows))}
+	for row, fields := range rows {
+		sh.rows[row] = make([]CellValue, len(fields))
+		for col, field := range fields {
+			sh.rows[row][col] = csvCellValue(field)
+		}
+		if len(fields) > sh.maxCol {
+			sh.maxCol = len(fields)
+		}
+	}
+	return sh
+}
+
+// a workbook read completely into memory, eg. a .xls workbook
+type gridBook struct {
+	sheets []*gridSheet
+}
+
+// a sheet read completely into memory
+type gridSheet struct {
+	name   string
+	rows   [][]CellValue
+	maxCol int
+	merged []MergedRange
+}
+
+func (s *gridSheet) Name() string {
+	return s.name
+}
+
+func (s *gridSheet) MaxCol() int {
+	return s.maxCol
+}
+
+func (s *gridSheet) MaxRow() int {
+	return len(s.rows)
+}
+
+func (s *gridSheet) Rows() RowIterator {
+	return newCountRows(len(s.rows))
+}
+
+func (s *gridSheet) Cell(row, col int) CellValue {
+	if row < 0 || row >= len(s.rows) || col < 0 || col >= len(s.rows[row]) {
+		return CellValue{}
+	}
+	return s.rows[row][col]
+}
+
+func (s *gridSheet) MergedCells() []MergedRange {
+	return s.merged
+}
+
+func (s *gridSheet) set(row, col int, cv CellValue) {
+	for len(s.rows) <= row {
+		s.rows = append(s.rows, nil)
+	}
+	if len(s.rows[row]) <= col {
+		s.rows[row] = append(s.rows[row], make([]CellValue, col+1-len(s.rows[row]))...)
+	}
+	s.rows[row][col] = cv
+	if col+1 > s.maxCol {
+		s.maxCol = col + 1
+	}
+}
+
+func (b *gridBook) sheetNames() []string {
+	names := make([]string, len(b.sheets))
+	for i, sh := range b.sheets {
+		names[i] = sh.name
+	}
+	return names
+}
+
+// the sheets are read completely when the workbook is opened and never change, so need no locking
+func (b *gridBook) openSheet(sheetName string) (SheetSource, func(), error) {
+	for _, sh := range b.sheets {
+		if sh.name == sheetName {
+			return sh, func() {}, nil
+		}
+	}
+	return nil, nil, errors.New("could not find sheet:  " + sheetName)
+}
+
+func (b *gridBook) source(sheetName string) (SheetSource, error) {
+	src, _, err := b.openSheet(sheetName)
+	return src, err
+}
+
+// nothing to release, as the sheets are not backed by files
+func (b *gridBook) close() {}
+
+// fills the empty cells of merged ranges with the value of their top left cell, for Params.FillMerged
+type mergedSource struct {
+	SheetSource
+	anchors map[[2]int][2]int // row, col -> row, col of the top left cell
+}
+
+// wraps src if it has merged cells
+func fillMerged(src SheetSource) SheetSource {
+	merged := src.MergedCells()
+	if len(merged) == 0 {
+		return src
+	}
+	anchors := make(map[[2]int][2]int)
+	for _, m := range merged {
+		for row := m.FirstRow; row <= m.LastRow; row++ {
+			for col := m.FirstCol; col <= m.LastCol; col++ {
+				if row != m.FirstRow || col != m.FirstCol {
+					anchors[[2]int{row, col}] = [2]int{m.FirstRow, m.FirstCol}
+				}
+			}
+		}
+	}
+	return &mergedSource{SheetSource: src, anchors: anchors}
+}
+
+func (s *mergedSource) Cell(row, col int) CellValue {
+	cv := s.SheetSource.Cell(row, col)
+	if cv.Kind != CellEmpty {
+		return cv
+	}
+	if anchor, ok := s.anchors[[2]int{row, col}]; ok {
+		return s.SheetSource.Cell(anchor[0], anchor[1])
+	}
+	return cv
+}
+
+// the cells of a row of a source, usually the column headings
+func sourceRow(src SheetSource, row int) []CellValue {
+	cells := make([]CellValue, src.MaxCol())
+	for col := range cells {
+		cells[col] = src.Cell(row, col)
+	}
+	return cells
+}
+
+// the values of a row as displayed, without trailing empty cells
+func rowStrings(cells []CellValue) []string {
+	n := len(cells)
+	values := make([]string, len(cells))
+	for col, c := range cells {
+		values[col] = c.Display()
+	}
+	for n > 0 && values[n-1] == "" {
+		n--
+	}
+	return values[:n]
+}
+
+// Display returns the value of the cell as displayed
+func (cv CellValue) Display() string {
+	switch {
+	case cv.Formatted != "":
+		return cv.Formatted
+	case cv.xlsxCell != nil && cv.Kind != CellEmpty:
+		return cv.xlsxCell.String()
+	}
+	return cv.Value
+}
+
+// Float returns the value of the cell as a float, as xlsx.Cell.Float
+func (cv CellValue) Float() (float64, error) {
+	return strconv.ParseFloat(cv.Value, 64)
+}
+
+// formats an excel serial date the way the excel backends display dates without a specific format
+func formatSerialDate(f float64, date1904 bool) string {
+	t := xlsx.TimeFromExcelTime(f, date1904)
+	switch {
+	case f == math.Trunc(f):
+		return t.Format("2006-01-02")
+	case f < 1:
+		return t.Format("15:04:05")
+	}
+	return strings.TrimSuffix(t.Format("2006-01-02 15:04:05"), " 00:00:00")
+}
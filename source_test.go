@@ -0,0 +1,122 @@
+package excel_to_gorm
+
+import (
+	"testing"
+	"time"
+)
+
+// a SheetSource written outside the package, which streams its rows without knowing how many there are
+type streamSource struct {
+	rows [][]string
+	row  int
+}
+
+func (s *streamSource) Name() string               { return "stream" }
+func (s *streamSource) MaxCol() int                { return 2 }
+func (s *streamSource) Rows() RowIterator          { s.row = -1; return s }
+func (s *streamSource) MergedCells() []MergedRange { return nil }
+func (s *streamSource) Next() bool                 { s.row++; return s.row < len(s.rows) }
+func (s *streamSource) Row() int                   { return s.row }
+func (s *streamSource) Err() error                 { return nil }
+func (s *streamSource) Cell(row, col int) CellValue {
+	if row != s.row || col >= len(s.rows[row]) {
+		return CellValue{}
+	}
+	return CellValue{Kind: CellString, Value: s.rows[row][col]}
+}
+
+func TestCustomSheetSource(t *testing.T) {
+	src := &streamSource{rows: [][]string{{"Variety", "Weight"}, {"Gala", "3"}, {"Fuji", "4"}}}
+	out, err := SourceToSlice(src, &sheetApple{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.([]sheetApple); len(got) != 2 || got[1] != (sheetApple{"Fuji", 4}) {
+		t.Errorf("got %+v", got)
+	}
+}
+
+type regionPest struct {
+	Region string  `xtg:"col:Region"`
+	Name   string  `xtg:"col:Name"`
+	Cause  string  `xtg:"melt:colname"`
+	Loss   float64 `xtg:"melt:value"`
+}
+
+func TestFillMerged(t *testing.T) {
+	f := mkBook(t, map[string][][]interface{}{"S": {
+		{"Region", "Name", "Scab"},
+		{"North", "Gala", 1.0},
+		{nil, "Fuji", 2.0},
+	}})
+	sh := f.Sheet["S"]
+	cell, err := sh.Cell(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cell.VMerge = 1
+	src := NewXlsxSource(sh)
+	if got := src.MergedCells(); len(got) != 1 || got[0] != (MergedRange{FirstRow: 1, FirstCol: 0, LastRow: 2, LastCol: 0}) {
+		t.Errorf("MergedCells got %+v", got)
+	}
+
+	for _, fill := range []bool{false, true} {
+		out, err := SourceToSlice(NewXlsxSource(sh), &regionPest{}, Params{FillMerged: fill})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := out.([]regionPest)
+		want := ""
+		if fill {
+			want = "North"
+		}
+		if len(got) != 2 || got[1].Region != want {
+			t.Errorf("FillMerged %v: got %+v", fill, got)
+		}
+	}
+}
+
+func TestXlsxCellValue(t *testing.T) {
+	picked := time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC)
+	f := mkBook(t, map[string][][]interface{}{"S": {{"Gala", 1.5, true, picked, nil}}})
+	src := NewXlsxSource(f.Sheet["S"])
+	tests := []struct {
+		col    int
+		kind   CellKind
+		value  string
+		isTime bool
+	}{
+		{0, CellString, "Gala", false},
+		{1, CellNumber, "1.5", false},
+		{2, CellBool, "1", false},
+		{3, CellNumber, "44259", true},
+		{4, CellEmpty, "", false},
+		{9, CellEmpty, "", false},
+	}
+	for _, tt := range tests {
+		got := src.Cell(0, tt.col)
+		if got.Kind != tt.kind || got.Value != tt.value || got.IsTime != tt.isTime {
+			t.Errorf("column %v: got %+v, want kind %v value %q", tt.col, got, tt.kind, tt.value)
+		}
+	}
+}
+
+func TestGridSource(t *testing.T) {
+	src := NewGridSource("grid", [][]string{{"Name", "Weight"}, {"Gala", "1.5"}, {"Fuji"}})
+	if src.Name() != "grid" || src.MaxCol() != 2 {
+		t.Errorf("got name %v, MaxCol %v", src.Name(), src.MaxCol())
+	}
+	if got := src.Cell(1, 1); got.Kind != CellNumber || got.Value != "1.5" {
+		t.Errorf("got %+v", got)
+	}
+	if got := src.Cell(2, 1); got.Kind != CellEmpty {
+		t.Errorf("a missing cell got %+v", got)
+	}
+	rows := 0
+	for it := src.Rows(); it.Next(); {
+		rows++
+	}
+	if rows != 3 {
+		t.Errorf("got %v rows, want 3", rows)
+	}
+}
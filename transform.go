@@ -0,0 +1,206 @@
+package excel_to_gorm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tealeg/xlsx/v3"
+)
+
+// TransformFunc is a step of a transform: tag, which cleans up the text of a cell before it is converted.
+// args are the ; separated arguments in brackets, eg. ["-", ""] for replace(-;)
+type TransformFunc func(text string, args []string) (string, error)
+
+// TransformStep is a step of a transform: tag, eg. scale(100)
+type TransformStep struct {
+	Name string
+	Args []string
+}
+
+// the transforms known to transform: tags, by name
+var transforms = struct {
+	sync.RWMutex
+	funcs map[string]TransformFunc
+}{funcs: map[string]TransformFunc{
+	"trim":    transformTrim,
+	"upper":   transformUpper,
+	"lower":   transformLower,
+	"replace": transformReplace,
+	"ungroup": transformUngroup,
+	"scale":   transformScale,
+	"round":   transformRound,
+}}
+
+// RegisterTransform makes a transform available to transform: tags, replacing any of the same name,
+// including the built in trim, upper, lower, replace, ungroup, scale and round
+func RegisterTransform(name string, fn TransformFunc) {
+	transforms.Lock()
+	defer transforms.Unlock()
+	transforms.funcs[name] = fn
+}
+
+func lookupTransform(name string) TransformFunc {
+	transforms.RLock()
+	defer transforms.RUnlock()
+	return transforms.funcs[name]
+}
+
+// parses the parameter of a transform: tag, eg. trim|replace(-;)|upper
+func parseTransforms(param string) ([]TransformStep, error) {
+	var steps []TransformStep
+	for _, stepText := range strings.Split(param, "|") {
+		stepText = strings.TrimSpace(stepText)
+		step := TransformStep{Name: stepText}
+		if open := strings.Index(stepText, "("); open >= 0 {
+			if !strings.HasSuffix(stepText, ")") {
+				return nil, errors.New("missing ) in transform " + stepText)
+			}
+			step.Name = strings.TrimSpace(stepText[:open])
+			step.Args = strings.Split(stepText[open+1:len(stepText)-1], ";")
+		}
+		if step.Name == "" {
+			return nil, errors.New("empty transform in " + param)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// a step of a transform: tag resolved against the registered transforms
+type fieldTransform struct {
+	TransformStep
+	fn TransformFunc
+}
+
+// resolves the transform: tag of a field.  nil if it has none
+func newFieldTransforms(fp *FieldPlan) ([]fieldTransform, error) {
+	var steps []fieldTransform
+	for _, step := range fp.Tag.Transforms {
+		fn := lookupTransform(step.Name)
+		if fn == nil {
+			return nil, errors.New("field " + fp.Name + " uses transform " + step.Name + " which is not registered")
+		}
+		steps = append(steps, fieldTransform{TransformStep: step, fn: fn})
+	}
+	return steps, nil
+}
+
+// passes text through the transforms of a field
+func (sp *sheetPlan) transformText(text string, fldIx int) (string, error) {
+	for _, step := range sp.transforms[fldIx] {
+		var err error
+		text, err = step.fn(text, step.Args)
+		if err != nil {
+			return text, fmt.Errorf("transform %v: %w", step.Name, err)
+		}
+	}
+	return text, nil
+}
+
+// a cell with its text passed through the transforms of a field.  A number or date which is still a number
+// keeps its kind, so that eg. dates survive with their time moved to the new serial, otherwise the result is
+// read as text as if from a CSV file
+func (sp *sheetPlan) transformCell(cv CellValue, fldIx int) (CellValue, error) {
+	text, err := sp.transformText(cv.Value, fldIx)
+	if err != nil || text == cv.Value {
+		return cv, err
+	}
+	transformed := csvCellValue(text)
+	transformed.Formula = cv.Formula
+	if transformed.Kind == CellNumber && (cv.Kind == CellNumber || cv.Kind == CellDate) {
+		transformed.Kind = cv.Kind
+		transformed.fromText = cv.fromText
+		transformed.IsTime = cv.IsTime
+		transformed.date1904 = cv.date1904
+		if cv.Kind == CellDate {
+			serial, err := transformed.Float()
+			if err != nil {
+				return cv, err
+			}
+			transformed.Time = xlsx.TimeFromExcelTime(serial, cv.date1904)
+		}
+	}
+	return transformed, nil
+}
+
+func transformTrim(text string, args []string) (string, error) {
+	return strings.TrimSpace(text), nil
+}
+
+func transformUpper(text string, args []string) (string, error) {
+	return strings.ToUpper(text), nil
+}
+
+func transformLower(text string, args []string) (string, error) {
+	return strings.ToLower(text), nil
+}
+
+// replace(old;new) replaces every old with new.  replace(old) removes them
+func transformReplace(text string, args []string) (string, error) {
+	switch len(args) {
+	case 1:
+		return strings.Replace(text, args[0], "", -1), nil
+	case 2:
+		return strings.Replace(text, args[0], args[1], -1), nil
+	}
+	return text, errors.New("should be in the form replace(<old>;<new>)")
+}
+
+// ungroup removes thousands separators: commas and spaces of any width, or the separators given, eg. ungroup(.;')
+func transformUngroup(text string, args []string) (string, error) {
+	separators := []string{",", " ", "\u00a0", "\u202f"}
+	if len(args) > 0 {
+		separators = args
+	}
+	for _, sep := range separators {
+		text = strings.Replace(text, sep, "", -1)
+	}
+	return text, nil
+}
+
+// scale(n) multiplies a number by n, eg. scale(100) turns 0.37 into 37.  Empty text is left empty
+func transformScale(text string, args []string) (string, error) {
+	if len(args) != 1 {
+		return text, errors.New("should be in the form scale(<factor>)")
+	}
+	factor, err := strconv.ParseFloat(strings.TrimSpace(args[0]), 64)
+	if err != nil {
+		return text, errors.New("invalid factor " + args[0])
+	}
+	return transformNumber(text, func(f float64) float64 { return f * factor })
+}
+
+// round(n) rounds a number to n decimal places, or to a whole number if n is left out
+func transformRound(text string, args []string) (string, error) {
+	places := 0.0
+	if len(args) > 1 {
+		return text, errors.New("should be in the form round(<places>)")
+	}
+	if len(args) == 1 && strings.TrimSpace(args[0]) != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(args[0]))
+		if err != nil {
+			return text, errors.New("invalid number of places " + args[0])
+		}
+		places = float64(n)
+	}
+	scale := math.Pow(10, places)
+	return transformNumber(text, func(f float64) float64 { return math.Round(f*scale) / scale })
+}
+
+// applies arithmetic to text holding a number, formatted to the 15 significant digits excel keeps
+// so that eg. 0.37*100 is 37 rather than 37.00000000000001
+func transformNumber(text string, op func(float64) float64) (string, error) {
+	if strings.TrimSpace(text) == "" {
+		return text, nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+	if err != nil {
+		return text, errors.New(text + " is not a number")
+	}
+	f, _ = strconv.ParseFloat(strconv.FormatFloat(op(f), 'g', 15, 64), 64)
+	return strconv.FormatFloat(f, 'f', -1, 64), nil
+}
@@ -0,0 +1,116 @@
+package excel_to_gorm
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type transformRow struct {
+	Code  string    `xtg:"col:Code,transform:trim|upper"`
+	Pct   float64   `xtg:"col:Pct,transform:scale(100)"`
+	Amt   int       `xtg:"col:Amt,transform:ungroup"`
+	R     float64   `xtg:"col:R,transform:round(1)"`
+	Kind  int       `xtg:"col:Kind,transform:trim|lower|replace(-; ),lookup:kinds"`
+	When  time.Time `xtg:"col:When,transform:trim"`
+	Cause string    `xtg:"melt:colname,transform:lower"`
+	Loss  float64   `xtg:"melt:value,transform:scale(0.5)"`
+}
+
+func TestTransforms(t *testing.T) {
+	wb := mkBook(t, map[string][][]interface{}{"S": {
+		{"Code", "Pct", "Amt", "R", "Kind", "When", "SCAB", "Rot"},
+		{"  ab1 ", 0.37, "1,234,567", 2.26, " Cooking-Apple", time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC), 4, 6},
+	}})
+	out, err := WorksheetToSlice(wb.Sheet["S"], &transformRow{}, Params{Lookups: map[string]map[string]string{"kinds": {"cooking apple": "7"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]transformRow)
+	want := transformRow{"AB1", 37, 1234567, 2.3, 7, time.Date(2021, 3, 4, 0, 0, 0, 0, time.UTC), "scab", 2}
+	if len(got) != 2 || got[0] != want || got[1].Cause != "rot" || got[1].Loss != 3 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+type roundedDate struct {
+	When time.Time `xtg:"col:When,transform:round"`
+}
+
+// dates keep their kind through transforms, with the time following the new serial
+func TestTransformDates(t *testing.T) {
+	wb := mkBook(t, map[string][][]interface{}{"S": {{"When"}, {time.Date(2021, 3, 4, 18, 0, 0, 0, time.UTC)}}})
+	out, err := WorksheetToSlice(wb.Sheet["S"], &roundedDate{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.([]roundedDate)[0].When; !got.Equal(time.Date(2021, 3, 5, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %v", got)
+	}
+
+	wb = mkBook(t, map[string][][]interface{}{"S": {{"When"}, {42797.75}}})
+	wb.Date1904 = true
+	out, err = WorksheetToSlice(wb.Sheet["S"], &roundedDate{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.([]roundedDate)[0].When; !got.Equal(time.Date(2021, 3, 5, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %v, expected a date counted from 1904", got)
+	}
+
+	sp := &sheetPlan{transforms: [][]fieldTransform{{{TransformStep: TransformStep{Name: "round"}, fn: transformRound}}}}
+	date := CellValue{Kind: CellDate, Value: "44259.75", IsTime: true, Time: time.Date(2021, 3, 4, 18, 0, 0, 0, time.UTC), date1904: true}
+	cv, err := sp.transformCell(date, 0)
+	if err != nil || cv.Kind != CellDate || !cv.IsTime || !cv.date1904 || cv.Value != "44260" || !cv.Time.Equal(time.Date(2025, 3, 6, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got %+v, %v", cv, err)
+	}
+}
+
+func TestRegisterTransform(t *testing.T) {
+	RegisterTransform("reverse", func(text string, args []string) (string, error) {
+		if text == "bad" {
+			return text, errors.New("cannot reverse bad")
+		}
+		r := []rune(text)
+		for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+			r[i], r[j] = r[j], r[i]
+		}
+		return string(r), nil
+	})
+	type reversed struct {
+		Code string `xtg:"col:Code,transform:reverse"`
+	}
+	out, err := SourceToSlice(NewGridSource("s", [][]string{{"Code"}, {"abc"}}), &reversed{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := out.([]reversed)[0].Code; got != "cba" {
+		t.Errorf("got %v", got)
+	}
+	if _, err := SourceToSlice(NewGridSource("s", [][]string{{"Code"}, {"bad"}}), &reversed{}, Params{}); err == nil {
+		t.Error("expected the error of the transform")
+	}
+}
+
+func TestInvalidTransforms(t *testing.T) {
+	rows := [][]string{{"Code", "Pct"}, {"x", "abc"}}
+	type notNumber struct {
+		Pct float64 `xtg:"col:Pct,transform:scale(100)"`
+	}
+	if _, err := SourceToSlice(NewGridSource("s", rows), &notNumber{}, Params{}); err == nil {
+		t.Error("expected an error scaling text")
+	}
+	type unknown struct {
+		Code string `xtg:"col:Code,transform:nope"`
+	}
+	if _, err := SourceToSlice(NewGridSource("s", rows), &unknown{}, Params{}); err == nil {
+		t.Error("expected an error for an unregistered transform")
+	}
+	if _, err := parseTransforms("trim|scale(2"); err == nil {
+		t.Error("expected an error for a missing )")
+	}
+	steps, err := parseTransforms("trim | replace(-;)")
+	if err != nil || len(steps) != 2 || steps[1].Name != "replace" || len(steps[1].Args) != 2 || steps[1].Args[0] != "-" {
+		t.Errorf("got %+v, %v", steps, err)
+	}
+}
@@ -0,0 +1,260 @@
+package excel_to_gorm
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+
+	"github.com/tealeg/xlsx/v3"
+)
+
+// Workbook is an open workbook, parsed once and shared by all the operations on it.
+// Unlike WorkbookToSlice, ToSlice leaves the sheet open so it can be read again.
+// Call Close when done to release the sheets.  Safe for concurrent use.
+// .xlsx, .xlsm, .xltx, legacy .xls (Excel 97 and later) and OpenDocument .ods workbooks are supported.
+// The format is detected from the contents of the file rather than its extension
+type Workbook struct {
+	fileName string
+	book     book
+	mu       sync.Mutex
+	closed   bool
+}
+
+// the parsed contents of a Workbook, whatever the format of the file
+type book interface {
+	sheetNames() []string
+	// the source for a sheet, and a function to call when done with it
+	openSheet(sheetName string) (SheetSource, func(), error)
+	// the source for a sheet, safe to use alongside other operations
+	source(sheetName string) (SheetSource, error)
+	close()
+}
+
+// opens and parses the workbook at path
+func Open(path string) (*Workbook, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.New("could not open file: " + path)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, errors.New("could not open file: " + path)
+	}
+	bk, err := parseBook(f, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("could not open file: %v. %w", path, err)
+	}
+	return &Workbook{fileName: path, book: bk}, nil
+}
+
+// parses a workbook from r, eg. an uploaded multipart.File.  fileName is used for meta:file tags and may be empty
+func OpenReader(r io.ReaderAt, size int64, fileName string) (*Workbook, error) {
+	bk, err := parseBook(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("could not read workbook: %w", err)
+	}
+	return &Workbook{fileName: fileName, book: bk}, nil
+}
+
+// picks the backend for a workbook from its first bytes
+func parseBook(r io.ReaderAt, size int64) (book, error) {
+	magic := make([]byte, 8)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return nil, errors.New("file is too short to be a workbook")
+	}
+	switch {
+	case bytes.Equal(magic, cfbSignature):
+		data := make([]byte, size)
+		if _, err := r.ReadAt(data, 0); err != nil && err != io.EOF {
+			return nil, err
+		}
+		return parseXLS(data)
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			return nil, err
+		}
+		if isODS(zr) {
+			return parseODS(zr)
+		}
+		wb, err := xlsx.OpenReaderAt(r, size)
+		if err != nil {
+			return nil, err
+		}
+		return &xlsxBook{wb: wb}, nil
+	}
+	return nil, errors.New("unrecognised file format. expected .xlsx, .xlsm, .xltx, .xls or .ods")
+}
+
+// the name the workbook was opened with
+func (w *Workbook) FileName() string {
+	return w.fileName
+}
+
+// names of the sheets, in workbook order
+func (w *Workbook) SheetNames() []string {
+	return w.book.sheetNames()
+}
+
+// maps sheet names to their 0 based position in the workbook
+func (w *Workbook) SheetIndex() map[string]int {
+	names := w.book.sheetNames()
+	sheetMap := make(map[string]int, len(names))
+	for i, name := range names {
+		sheetMap[name] = i
+	}
+	return sheetMap
+}
+
+// the values of a row of the sheet, usually the column headings.  headerRow starts at 1
+func (w *Workbook) Headings(sheetName string, headerRow int) ([]string, error) {
+	src, done, err := w.openSheet(sheetName)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	if headerRow < 1 {
+		return nil, fmt.Errorf("header row %v is invalid. rows start at 1", headerRow)
+	}
+	if headerRow > sourceMaxRow(src) {
+		return nil, fmt.Errorf("header row %v is beyond the last row of sheet: %v", headerRow, sheetName)
+	}
+	return rowStrings(sourceRow(src, headerRow-1)), nil
+}
+
+// the number of rows and columns used by the sheet
+func (w *Workbook) Dimensions(sheetName string) (rows int, cols int, err error) {
+	src, done, err := w.openSheet(sheetName)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer done()
+	return sourceMaxRow(src), src.MaxCol(), nil
+}
+
+// converts a sheet into a slice of the model, as WorksheetToSlice.  The sheet stays open
+func (w *Workbook) ToSlice(sheetName string, model interface{}, params Params) (interface{}, error) {
+	src, done, err := w.openSheet(sheetName)
+	if err != nil {
+		modelTyp := reflect.ValueOf(model).Elem().Type()
+		return reflect.Zero(reflect.SliceOf(modelTyp)).Interface(), err
+	}
+	defer done()
+	if params.FileName == "" {
+		params.FileName = w.fileName
+	}
+	return SourceToSlice(src, model, params)
+}
+
+// the sheet as a SheetSource, to read its cells directly.  Reads of an .xlsx sheet take turns with
+// the other operations on it, and return empty cells once the workbook is closed
+func (w *Workbook) Source(sheetName string) (SheetSource, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil, errors.New("workbook has been closed")
+	}
+	return w.book.source(sheetName)
+}
+
+// releases the sheets of the workbook.  The workbook cannot be used afterwards
+func (w *Workbook) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	w.closed = true
+	w.book.close()
+}
+
+func (w *Workbook) openSheet(sheetName string) (SheetSource, func(), error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil, nil, errors.New("workbook has been closed")
+	}
+	return w.book.openSheet(sheetName)
+}
+
+// the number of rows of a source, counting them if it does not know
+func sourceMaxRow(src SheetSource) int {
+	if counter, ok := src.(rowCounter); ok {
+		return counter.MaxRow()
+	}
+	rows := 0
+	for it := src.Rows(); it.Next(); {
+		rows = it.Row() + 1
+	}
+	return rows
+}
+
+// an .xlsx workbook, read by tealeg/xlsx
+type xlsxBook struct {
+	wb *xlsx.File
+}
+
+func (b *xlsxBook) sheetNames() []string {
+	return WorkbookSheetNames(b.wb)
+}
+
+func (b *xlsxBook) openSheet(sheetName string) (SheetSource, func(), error) {
+	sh, ok := b.wb.Sheet[sheetName]
+	if !ok {
+		return nil, nil, errors.New("could not find sheet:  " + sheetName)
+	}
+	guard, err := lockSheet(sh)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newXlsxSource(sh), guard.mu.Unlock, nil
+}
+
+func (b *xlsxBook) source(sheetName string) (SheetSource, error) {
+	sh, ok := b.wb.Sheet[sheetName]
+	if !ok {
+		return nil, errors.New("could not find sheet:  " + sheetName)
+	}
+	return &guardedXlsxSource{xlsxSource: newXlsxSource(sh)}, nil
+}
+
+// an xlsxSource which locks the sheet for each read
+type guardedXlsxSource struct {
+	*xlsxSource
+}
+
+func (s *guardedXlsxSource) Cell(row, col int) CellValue {
+	guard, err := lockSheet(s.sh)
+	if err != nil {
+		return CellValue{}
+	}
+	defer guard.mu.Unlock()
+	return s.xlsxSource.Cell(row, col)
+}
+
+func (s *guardedXlsxSource) MergedCells() []MergedRange {
+	guard, err := lockSheet(s.sh)
+	if err != nil {
+		return nil
+	}
+	defer guard.mu.Unlock()
+	return s.xlsxSource.MergedCells()
+}
+
+func (b *xlsxBook) close() {
+	for _, sh := range b.wb.Sheets {
+		guard, err := lockSheet(sh)
+		if err != nil {
+			// already closed
+			continue
+		}
+		guard.closeSheet(sh)
+		guard.mu.Unlock()
+	}
+}
@@ -0,0 +1,112 @@
+package excel_to_gorm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func openAppleBook(t *testing.T) *Workbook {
+	data := appleBook(t)
+	wb, err := OpenReader(bytes.NewReader(data), int64(len(data)), "apples.xlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wb
+}
+
+func TestWorkbookMetadataMethods(t *testing.T) {
+	wb := openAppleBook(t)
+	defer wb.Close()
+	if got := wb.FileName(); got != "apples.xlsx" {
+		t.Errorf("FileName got %v", got)
+	}
+	if got := wb.SheetIndex(); len(got) != 2 || got["apples"] != 0 || got["pears"] != 1 {
+		t.Errorf("SheetIndex got %v", got)
+	}
+	if got, err := wb.Headings("apples", 1); err != nil || len(got) != 2 || got[0] != "Variety" {
+		t.Errorf("Headings got %v, %v", got, err)
+	}
+	if _, err := wb.Headings("apples", 0); err == nil {
+		t.Error("expected an error for heading row 0")
+	}
+	if rows, cols, err := wb.Dimensions("apples"); err != nil || rows != 3 || cols != 2 {
+		t.Errorf("Dimensions got %v rows, %v cols, %v", rows, cols, err)
+	}
+}
+
+func TestWorkbookToSliceConcurrently(t *testing.T) {
+	wb := openAppleBook(t)
+	defer wb.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := wb.ToSlice("apples", &sheetApple{}, Params{})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if n := len(out.([]sheetApple)); n != 2 {
+				t.Errorf("got %v records, want 2", n)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClosedWorkbook(t *testing.T) {
+	wb := openAppleBook(t)
+	wb.Close()
+	wb.Close()
+	if _, err := wb.ToSlice("apples", &sheetApple{}, Params{}); err == nil {
+		t.Error("expected an error converting a sheet of a closed workbook")
+	}
+}
+
+func TestOpenMissingFile(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.xlsx")); err == nil {
+		t.Error("expected an error opening a missing file")
+	}
+}
+
+// macro enabled workbooks and templates differ from .xlsx files only in the content type of the workbook
+func TestOpenMacroAndTemplateBooks(t *testing.T) {
+	contentTypes := map[string]string{
+		".xlsm": "application/vnd.ms-excel.sheet.macroEnabled.main+xml",
+		".xltx": "application/vnd.openxmlformats-officedocument.spreadsheetml.template.main+xml",
+	}
+	for ext, contentType := range contentTypes {
+		replaced := false
+		data := rewriteZip(t, appleBook(t), func(name string, content []byte) []byte {
+			if name != "[Content_Types].xml" {
+				return content
+			}
+			edited := bytes.Replace(content, []byte("application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"), []byte(contentType), 1)
+			replaced = !bytes.Equal(edited, content)
+			return edited
+		})
+		if !replaced {
+			t.Fatal("the workbook has no content type to replace")
+		}
+		path := filepath.Join(t.TempDir(), "apples"+ext)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		wb, err := Open(path)
+		if err != nil {
+			t.Errorf("%v: %v", ext, err)
+			continue
+		}
+		out, err := wb.ToSlice("apples", &sheetApple{}, Params{})
+		wb.Close()
+		if err != nil {
+			t.Errorf("%v: %v", ext, err)
+		} else if n := len(out.([]sheetApple)); n != 2 {
+			t.Errorf("%v: got %v records, want 2", ext, n)
+		}
+	}
+}
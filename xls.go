@@ -0,0 +1,667 @@
+package excel_to_gorm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/tealeg/xlsx/v3"
+)
+
+// Reader for legacy .xls workbooks: BIFF8 records held in the "Workbook" stream of a compound file.
+// Only the cell values are read.  Earlier BIFF versions and encrypted workbooks are not supported
+
+// signature at the start of a compound file, and so of every .xls workbook
+var cfbSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+const (
+	cfbEndOfChain = 0xFFFFFFFE
+	cfbFreeSect   = 0xFFFFFFFF
+	cfbDirEntry   = 128
+)
+
+// the sectors of a compound file, enough to read its streams
+type cfbFile struct {
+	data           []byte
+	sectorSize     int
+	miniSectorSize int
+	miniCutoff     int
+	fat            []uint32
+	miniFat        []uint32
+	miniStream     []byte
+	dir            []byte
+}
+
+func parseCFB(data []byte) (*cfbFile, error) {
+	if len(data) < 512 || string(data[:8]) != string(cfbSignature) {
+		return nil, errors.New("not a compound file")
+	}
+	le := binary.LittleEndian
+	f := &cfbFile{
+		data:           data,
+		sectorSize:     1 << le.Uint16(data[0x1E:]),
+		miniSectorSize: 1 << le.Uint16(data[0x20:]),
+		miniCutoff:     int(le.Uint32(data[0x38:])),
+	}
+	if f.sectorSize != 512 && f.sectorSize != 4096 {
+		return nil, fmt.Errorf("unsupported compound file sector size %v", f.sectorSize)
+	}
+
+	// the sectors of the FAT are listed in the header, then in a chain of DIFAT sectors
+	var fatSectors []uint32
+	for i := 0; i < 109; i++ {
+		sect := le.Uint32(data[0x4C+4*i:])
+		if sect != cfbFreeSect {
+			fatSectors = append(fatSectors, sect)
+		}
+	}
+	perSector := f.sectorSize/4 - 1
+	difat := le.Uint32(data[0x44:])
+	for n := le.Uint32(data[0x48:]); n > 0 && difat != cfbEndOfChain && difat != cfbFreeSect; n-- {
+		sector, err := f.sector(difat)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < perSector; i++ {
+			sect := le.Uint32(sector[4*i:])
+			if sect != cfbFreeSect {
+				fatSectors = append(fatSectors, sect)
+			}
+		}
+		difat = le.Uint32(sector[4*perSector:])
+	}
+	for _, sect := range fatSectors {
+		sector, err := f.sector(sect)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < f.sectorSize/4; i++ {
+			f.fat = append(f.fat, le.Uint32(sector[4*i:]))
+		}
+	}
+
+	var err error
+	f.dir, err = f.chain(le.Uint32(data[0x30:]), -1)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory: %w", err)
+	}
+	if len(f.dir) < cfbDirEntry {
+		return nil, errors.New("compound file has no root directory entry")
+	}
+	// the root entry holds the mini stream, which holds the small streams
+	f.miniStream, err = f.chain(le.Uint32(f.dir[0x74:]), int(le.Uint64(f.dir[0x78:])))
+	if err != nil {
+		return nil, fmt.Errorf("could not read mini stream: %w", err)
+	}
+	miniFat, err := f.chain(le.Uint32(data[0x3C:]), -1)
+	if err != nil {
+		return nil, fmt.Errorf("could not read mini FAT: %w", err)
+	}
+	for i := 0; i+4 <= len(miniFat); i += 4 {
+		f.miniFat = append(f.miniFat, le.Uint32(miniFat[i:]))
+	}
+	return f, nil
+}
+
+func (f *cfbFile) sector(sect uint32) ([]byte, error) {
+	start := (int(sect) + 1) * f.sectorSize
+	if sect >= cfbEndOfChain-1 || start+f.sectorSize > len(f.data) {
+		// the last sector of a file is sometimes truncated
+		if start < len(f.data) && sect < cfbEndOfChain-1 {
+			sector := make([]byte, f.sectorSize)
+			copy(sector, f.data[start:])
+			return sector, nil
+		}
+		return nil, fmt.Errorf("sector %v is beyond the end of the file", sect)
+	}
+	return f.data[start : start+f.sectorSize], nil
+}
+
+// reads a chain of sectors.  size is -1 to read the whole chain
+func (f *cfbFile) chain(start uint32, size int) ([]byte, error) {
+	var out []byte
+	for sect, n := start, 0; sect != cfbEndOfChain && sect != cfbFreeSect; n++ {
+		if n > len(f.fat) {
+			return nil, errors.New("sector chain loops")
+		}
+		sector, err := f.sector(sect)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sector...)
+		if int(sect) >= len(f.fat) {
+			return nil, fmt.Errorf("sector %v is not in the FAT", sect)
+		}
+		sect = f.fat[sect]
+	}
+	if size >= 0 {
+		if size > len(out) {
+			return nil, errors.New("stream is shorter than its directory entry")
+		}
+		out = out[:size]
+	}
+	return out, nil
+}
+
+// reads a chain of sectors of the mini stream
+func (f *cfbFile) miniChain(start uint32, size int) ([]byte, error) {
+	var out []byte
+	for sect, n := start, 0; sect != cfbEndOfChain && sect != cfbFreeSect; n++ {
+		if n > len(f.miniFat) || int(sect) >= len(f.miniFat) {
+			return nil, errors.New("mini sector chain is invalid")
+		}
+		begin := int(sect) * f.miniSectorSize
+		if begin+f.miniSectorSize > len(f.miniStream) {
+			return nil, fmt.Errorf("mini sector %v is beyond the end of the mini stream", sect)
+		}
+		out = append(out, f.miniStream[begin:begin+f.miniSectorSize]...)
+		sect = f.miniFat[sect]
+	}
+	if size > len(out) {
+		return nil, errors.New("stream is shorter than its directory entry")
+	}
+	return out[:size], nil
+}
+
+// the contents of the stream with a given name.  ok is false if there is none
+func (f *cfbFile) stream(name string) (data []byte, ok bool, err error) {
+	le := binary.LittleEndian
+	for off := 0; off+cfbDirEntry <= len(f.dir); off += cfbDirEntry {
+		entry := f.dir[off : off+cfbDirEntry]
+		nameLen := int(le.Uint16(entry[0x40:]))
+		if entry[0x42] != 2 || nameLen < 2 || nameLen > 64 {
+			continue
+		}
+		units := make([]uint16, nameLen/2-1)
+		for i := range units {
+			units[i] = le.Uint16(entry[2*i:])
+		}
+		if !strings.EqualFold(string(utf16.Decode(units)), name) {
+			continue
+		}
+		start := le.Uint32(entry[0x74:])
+		size := int(le.Uint32(entry[0x78:]))
+		if size < f.miniCutoff {
+			data, err = f.miniChain(start, size)
+		} else {
+			data, err = f.chain(start, size)
+		}
+		return data, true, err
+	}
+	return nil, false, nil
+}
+
+// BIFF8 record types
+const (
+	biffFormula    = 0x0006
+	biffEOF        = 0x000A
+	biffDateMode   = 0x0022
+	biffFilePass   = 0x002F
+	biffContinue   = 0x003C
+	biffBoundSheet = 0x0085
+	biffMulRK      = 0x00BD
+	biffXF         = 0x00E0
+	biffMergeCells = 0x00E5
+	biffSST        = 0x00FC
+	biffLabelSST   = 0x00FD
+	biffNumber     = 0x0203
+	biffLabel      = 0x0204
+	biffBoolErr    = 0x0205
+	biffString     = 0x0207
+	biffRK         = 0x027E
+	biffBOF        = 0x0809
+	biffFormat     = 0x041E
+)
+
+// a record of the workbook stream, with the data of any CONTINUE records which follow it
+type biffRecord struct {
+	typ  uint16
+	data []byte
+	// offsets in data where each CONTINUE record starts.  Strings split by a CONTINUE restate their width
+	breaks []int
+}
+
+// reads the record at off, returning the offset of the next one
+func readBiffRecord(stream []byte, off int) (biffRecord, int, error) {
+	le := binary.LittleEndian
+	if off+4 > len(stream) {
+		return biffRecord{}, off, errors.New("workbook stream ends inside a record header")
+	}
+	rec := biffRecord{typ: le.Uint16(stream[off:])}
+	size := int(le.Uint16(stream[off+2:]))
+	off += 4
+	if off+size > len(stream) {
+		return rec, off, errors.New("workbook stream ends inside a record")
+	}
+	rec.data = stream[off : off+size]
+	off += size
+	for off+4 <= len(stream) && le.Uint16(stream[off:]) == biffContinue {
+		size = int(le.Uint16(stream[off+2:]))
+		if off+4+size > len(stream) {
+			return rec, off, errors.New("workbook stream ends inside a record")
+		}
+		if rec.breaks == nil {
+			// copy before appending, so the stream is left as it was
+			rec.data = append([]byte(nil), rec.data...)
+		}
+		rec.breaks = append(rec.breaks, len(rec.data))
+		rec.data = append(rec.data, stream[off+4:off+4+size]...)
+		off += 4 + size
+	}
+	return rec, off, nil
+}
+
+// reads the fields of a record, keeping track of where CONTINUE records split it
+type biffReader struct {
+	rec biffRecord
+	pos int
+	err error
+}
+
+func (r *biffReader) need(n int) bool {
+	if r.err == nil && r.pos+n > len(r.rec.data) {
+		r.err = fmt.Errorf("record 0x%04X is too short", r.rec.typ)
+	}
+	return r.err == nil
+}
+
+func (r *biffReader) u8() byte {
+	if !r.need(1) {
+		return 0
+	}
+	r.pos++
+	return r.rec.data[r.pos-1]
+}
+
+func (r *biffReader) u16() uint16 {
+	if !r.need(2) {
+		return 0
+	}
+	r.pos += 2
+	return binary.LittleEndian.Uint16(r.rec.data[r.pos-2:])
+}
+
+func (r *biffReader) u32() uint32 {
+	if !r.need(4) {
+		return 0
+	}
+	r.pos += 4
+	return binary.LittleEndian.Uint32(r.rec.data[r.pos-4:])
+}
+
+func (r *biffReader) skip(n int) {
+	if r.need(n) {
+		r.pos += n
+	}
+}
+
+// the next CONTINUE boundary after the current position, or the end of the record
+func (r *biffReader) nextBreak() int {
+	for _, b := range r.rec.breaks {
+		if b > r.pos {
+			return b
+		}
+	}
+	return len(r.rec.data)
+}
+
+// reads a string of cch characters following its flags byte.  When a CONTINUE record splits the
+// characters, it starts with a new flags byte as the width of the characters may change
+func (r *biffReader) chars(cch int, flags byte) string {
+	units := make([]uint16, 0, cch)
+	for len(units) < cch && r.err == nil {
+		end := r.nextBreak()
+		for len(units) < cch && r.pos < end {
+			if flags&0x01 == 0 {
+				units = append(units, uint16(r.rec.data[r.pos]))
+				r.pos++
+				continue
+			}
+			if r.pos+2 > end {
+				r.err = errors.New("string is split inside a character")
+				break
+			}
+			units = append(units, binary.LittleEndian.Uint16(r.rec.data[r.pos:]))
+			r.pos += 2
+		}
+		if len(units) < cch {
+			if r.pos >= len(r.rec.data) {
+				r.err = fmt.Errorf("record 0x%04X ends inside a string", r.rec.typ)
+				break
+			}
+			flags = r.u8()
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// XLUnicodeRichExtendedString as used by the SST, or XLUnicodeString when it has no runs or phonetic data
+func (r *biffReader) unicodeString(cch int) string {
+	flags := r.u8()
+	runs := 0
+	extSize := 0
+	if flags&0x08 != 0 {
+		runs = int(r.u16())
+	}
+	if flags&0x04 != 0 {
+		extSize = int(r.u32())
+	}
+	s := r.chars(cch, flags)
+	r.skip(4*runs + extSize)
+	return s
+}
+
+// the number formats built into excel which show dates or times
+var xlsDateFormats = map[int]bool{
+	14: true, 15: true, 16: true, 17: true, 18: true, 19: true, 20: true, 21: true, 22: true,
+	27: true, 28: true, 29: true, 30: true, 31: true, 32: true, 33: true, 34: true, 35: true, 36: true,
+	45: true, 46: true, 47: true, 50: true, 51: true, 52: true, 53: true, 54: true, 55: true, 56: true, 57: true, 58: true,
+}
+
+// whether a custom number format shows a date or time.  Literal text, colours and conditions are ignored
+func isDateFormat(format string) bool {
+	inQuote := false
+	inBracket := false
+	bracket := ""
+	for i := 0; i < len(format); i++ {
+		ch := format[i]
+		switch {
+		case inQuote:
+			inQuote = ch != '"'
+		case inBracket:
+			if ch == ']' {
+				inBracket = false
+				// elapsed times such as [h]:mm
+				b := strings.ToLower(bracket)
+				if b != "" && strings.Trim(b, "hms") == "" {
+					return true
+				}
+			} else {
+				bracket += string(ch)
+			}
+		case ch == '"':
+			inQuote = true
+		case ch == '[':
+			inBracket = true
+			bracket = ""
+		case ch == '\\' || ch == '_' || ch == '*':
+			i++
+		default:
+			switch ch {
+			case 'd', 'D', 'm', 'M', 'y', 'Y', 'h', 'H', 's', 'S':
+				return !strings.EqualFold(format, "General")
+			}
+		}
+	}
+	return false
+}
+
+// state of the workbook globals needed to read the cells of its sheets
+type xlsGlobals struct {
+	date1904 bool
+	formats  map[int]string // custom number formats by id
+	xfFormat []int          // number format id of each XF (cell format)
+	sst      []string
+}
+
+type xlsSheetEntry struct {
+	name string
+	pos  int // offset of the sheet's BOF in the workbook stream
+}
+
+// parses a .xls workbook held in memory
+func parseXLS(data []byte) (*gridBook, error) {
+	cfb, err := parseCFB(data)
+	if err != nil {
+		return nil, err
+	}
+	stream, ok, err := cfb.stream("Workbook")
+	if err != nil {
+		return nil, fmt.Errorf("could not read workbook stream: %w", err)
+	}
+	if !ok {
+		if _, ok, _ := cfb.stream("Book"); ok {
+			return nil, errors.New("workbooks saved by Excel 95 or earlier are not supported")
+		}
+		return nil, errors.New("compound file has no workbook stream")
+	}
+
+	g := &xlsGlobals{formats: make(map[int]string)}
+	var entries []xlsSheetEntry
+	for off := 0; off < len(stream); {
+		var rec biffRecord
+		rec, off, err = readBiffRecord(stream, off)
+		if err != nil {
+			return nil, err
+		}
+		r := &biffReader{rec: rec}
+		switch rec.typ {
+		case biffBOF:
+			if r.u16() != 0x0600 {
+				return nil, errors.New("only BIFF8 workbooks (Excel 97 and later) are supported")
+			}
+		case biffFilePass:
+			return nil, errors.New("workbook is encrypted")
+		case biffDateMode:
+			g.date1904 = r.u16() == 1
+		case biffFormat:
+			id := int(r.u16())
+			g.formats[id] = r.unicodeString(int(r.u16()))
+		case biffXF:
+			r.skip(2)
+			g.xfFormat = append(g.xfFormat, int(r.u16()))
+		case biffBoundSheet:
+			pos := int(r.u32())
+			r.skip(1)
+			kind := r.u8()
+			name := r.unicodeString(int(r.u8()))
+			// charts and macro sheets hold no cells
+			if kind == 0 {
+				entries = append(entries, xlsSheetEntry{name: name, pos: pos})
+			}
+		case biffSST:
+			r.skip(8)
+			for r.pos < len(rec.data) && r.err == nil {
+				g.sst = append(g.sst, r.unicodeString(int(r.u16())))
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		if rec.typ == biffEOF {
+			break
+		}
+	}
+
+	book := &gridBook{}
+	for _, entry := range entries {
+		sh, err := g.readSheet(stream, entry)
+		if err != nil {
+			return nil, fmt.Errorf("could not read sheet: %v. %w", entry.name, err)
+		}
+		book.sheets = append(book.sheets, sh)
+	}
+	return book, nil
+}
+
+// reads the cells of a sheet from its BOF to its EOF
+func (g *xlsGlobals) readSheet(stream []byte, entry xlsSheetEntry) (*gridSheet, error) {
+	sh := &gridSheet{name: entry.name}
+	if entry.pos < 0 || entry.pos >= len(stream) {
+		return nil, errors.New("sheet is beyond the end of the workbook stream")
+	}
+	// the cell and position of a formula whose string result follows in a STRING record
+	pendingRow, pendingCol := -1, -1
+	// charts embedded in the sheet have their own BOF and EOF
+	depth := 0
+	for off := entry.pos; off < len(stream); {
+		rec, next, err := readBiffRecord(stream, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next
+		switch rec.typ {
+		case biffBOF:
+			depth++
+		case biffEOF:
+			depth--
+		}
+		if depth == 0 {
+			break
+		}
+		if depth > 1 {
+			continue
+		}
+		r := &biffReader{rec: rec}
+		switch rec.typ {
+		case biffNumber:
+			row, col, xf := int(r.u16()), int(r.u16()), int(r.u16())
+			if r.need(8) {
+				sh.set(row, col, g.number(math.Float64frombits(binary.LittleEndian.Uint64(rec.data[6:])), xf))
+			}
+		case biffRK:
+			row, col, xf := int(r.u16()), int(r.u16()), int(r.u16())
+			sh.set(row, col, g.number(rkValue(r.u32()), xf))
+		case biffMulRK:
+			row, col := int(r.u16()), int(r.u16())
+			for ; r.pos+6 <= len(rec.data)-2 && r.err == nil; col++ {
+				xf := int(r.u16())
+				sh.set(row, col, g.number(rkValue(r.u32()), xf))
+			}
+		case biffLabelSST:
+			row, col := int(r.u16()), int(r.u16())
+			r.skip(2)
+			isst := int(r.u32())
+			if r.err == nil {
+				if isst >= len(g.sst) {
+					return nil, fmt.Errorf("cell %v refers to missing shared string %v", xlsx.GetCellIDStringFromCoords(col, row), isst)
+				}
+				sh.set(row, col, textCell(g.sst[isst]))
+			}
+		case biffLabel:
+			row, col := int(r.u16()), int(r.u16())
+			r.skip(2)
+			s := r.unicodeString(int(r.u16()))
+			sh.set(row, col, textCell(s))
+		case biffBoolErr:
+			row, col := int(r.u16()), int(r.u16())
+			r.skip(2)
+			val, isErr := r.u8(), r.u8()
+			if r.err == nil {
+				sh.set(row, col, boolErrCell(val, isErr == 1))
+			}
+		case biffFormula:
+			// only the saved result is read.  Excel always saves one, and the formula itself is
+			// compiled to tokens, so Formula is left empty
+			row, col, xf := int(r.u16()), int(r.u16()), int(r.u16())
+			if !r.need(8) {
+				break
+			}
+			result := rec.data[6:14]
+			if result[6] != 0xFF || result[7] != 0xFF {
+				sh.set(row, col, g.number(math.Float64frombits(binary.LittleEndian.Uint64(result)), xf))
+				break
+			}
+			switch result[0] {
+			case 0:
+				pendingRow, pendingCol = row, col
+			case 1:
+				sh.set(row, col, boolErrCell(result[2], false))
+			case 2:
+				sh.set(row, col, boolErrCell(result[2], true))
+			}
+		case biffMergeCells:
+			for n := int(r.u16()); n > 0 && r.err == nil; n-- {
+				m := MergedRange{FirstRow: int(r.u16()), LastRow: int(r.u16()), FirstCol: int(r.u16()), LastCol: int(r.u16())}
+				if r.err == nil {
+					sh.merged = append(sh.merged, m)
+				}
+			}
+		case biffString:
+			if pendingRow >= 0 {
+				s := r.unicodeString(int(r.u16()))
+				sh.set(pendingRow, pendingCol, textCell(s))
+				pendingRow, pendingCol = -1, -1
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	return sh, nil
+}
+
+// decodes an RK number, a compressed float or integer
+func rkValue(rk uint32) float64 {
+	var f float64
+	if rk&0x02 != 0 {
+		f = float64(int32(rk) >> 2)
+	} else {
+		f = math.Float64frombits(uint64(rk&0xFFFFFFFC) << 32)
+	}
+	if rk&0x01 != 0 {
+		f /= 100
+	}
+	return f
+}
+
+// a number cell, formatted according to its XF
+func (g *xlsGlobals) number(f float64, xf int) CellValue {
+	value := strconv.FormatFloat(f, 'f', -1, 64)
+	cv := CellValue{Kind: CellNumber, Value: value, Formatted: value, date1904: g.date1904}
+	if xf < 0 || xf >= len(g.xfFormat) {
+		return cv
+	}
+	id := g.xfFormat[xf]
+	format, custom := g.formats[id]
+	switch {
+	case xlsDateFormats[id] || (custom && isDateFormat(format)):
+		// decoded here, as only the workbook knows whether its dates count from 1900 or 1904
+		cv.Kind = CellDate
+		cv.IsTime = true
+		cv.Time = xlsx.TimeFromExcelTime(f, g.date1904)
+		cv.Formatted = formatSerialDate(f, g.date1904)
+	case id == 9:
+		cv.Formatted = strconv.FormatFloat(math.Round(f*100), 'f', -1, 64) + "%"
+	case id == 10:
+		cv.Formatted = strconv.FormatFloat(f*100, 'f', 2, 64) + "%"
+	}
+	return cv
+}
+
+func textCell(s string) CellValue {
+	if s == "" {
+		return CellValue{}
+	}
+	return CellValue{Kind: CellString, Value: s, Formatted: s}
+}
+
+// the text excel shows for each error code
+var xlsErrors = map[byte]string{
+	0x00: "#NULL!",
+	0x07: "#DIV/0!",
+	0x0F: "#VALUE!",
+	0x17: "#REF!",
+	0x1D: "#NAME?",
+	0x24: "#NUM!",
+	0x2A: "#N/A",
+	0x2B: "#GETTING_DATA",
+}
+
+func boolErrCell(val byte, isErr bool) CellValue {
+	if isErr {
+		text, ok := xlsErrors[val]
+		if !ok {
+			text = "#ERROR!"
+		}
+		return CellValue{Kind: CellError, Value: text, Formatted: text}
+	}
+	if val != 0 {
+		return CellValue{Kind: CellBool, Value: "1", Formatted: "TRUE"}
+	}
+	return CellValue{Kind: CellBool, Value: "0", Formatted: "FALSE"}
+}
@@ -0,0 +1,243 @@
+package excel_to_gorm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// a BIFF8 record
+func biff(typ uint16, fields ...interface{}) []byte {
+	var data bytes.Buffer
+	for _, f := range fields {
+		binary.Write(&data, binary.LittleEndian, f)
+	}
+	var rec bytes.Buffer
+	binary.Write(&rec, binary.LittleEndian, typ)
+	binary.Write(&rec, binary.LittleEndian, uint16(data.Len()))
+	rec.Write(data.Bytes())
+	return rec.Bytes()
+}
+
+// an uncompressed string of cch 8 bit characters, as in BOUNDSHEET, SST and STRING records
+func biffChars(s string) []byte {
+	return append([]byte{0}, s...)
+}
+
+type xlsCell struct {
+	row, col uint16
+	value    interface{} // string (shared), float64, bool, xlsFormulaText or xlsDate
+}
+
+type (
+	xlsFormulaText string  // a formula whose saved result is text
+	xlsDate        float64 // a serial date, formatted as a date
+)
+
+// the workbook stream of a workbook with a sheet of the given cells
+func xlsStream(sheetName string, cells []xlsCell) []byte {
+	var sst []string
+	sstIndex := map[string]int{}
+	for _, c := range cells {
+		if s, ok := c.value.(string); ok {
+			if _, found := sstIndex[s]; !found {
+				sstIndex[s] = len(sst)
+				sst = append(sst, s)
+			}
+		}
+	}
+
+	var sheet bytes.Buffer
+	sheet.Write(biff(biffBOF, uint16(0x0600), uint16(0x0010), uint32(0), uint32(0), uint32(0)))
+	for _, c := range cells {
+		switch v := c.value.(type) {
+		case string:
+			sheet.Write(biff(biffLabelSST, c.row, c.col, uint16(0), uint32(sstIndex[v])))
+		case float64:
+			sheet.Write(biff(biffNumber, c.row, c.col, uint16(0), v))
+		case xlsDate:
+			sheet.Write(biff(biffNumber, c.row, c.col, uint16(1), float64(v)))
+		case int:
+			// an RK integer
+			sheet.Write(biff(biffRK, c.row, c.col, uint16(0), uint32(v)<<2|2))
+		case bool:
+			val := byte(0)
+			if v {
+				val = 1
+			}
+			sheet.Write(biff(biffBoolErr, c.row, c.col, uint16(0), val, byte(0)))
+		case xlsFormulaText:
+			result := []byte{0, 0, 0, 0, 0, 0, 0xFF, 0xFF}
+			sheet.Write(biff(biffFormula, c.row, c.col, uint16(0), result, uint16(0), uint32(0), uint16(0)))
+			sheet.Write(biff(biffString, uint16(len(v)), biffChars(string(v))))
+		}
+	}
+	sheet.Write(biff(biffEOF))
+
+	var sstData []interface{}
+	sstData = append(sstData, uint32(len(sst)), uint32(len(sst)))
+	for _, s := range sst {
+		sstData = append(sstData, uint16(len(s)), biffChars(s))
+	}
+	globals := func(sheetPos uint32) []byte {
+		var g bytes.Buffer
+		g.Write(biff(biffBOF, uint16(0x0600), uint16(0x0005), uint32(0), uint32(0), uint32(0)))
+		// XF 0 is General, XF 1 the built in date format 14
+		g.Write(biff(biffXF, uint16(0), uint16(0), make([]byte, 16)))
+		g.Write(biff(biffXF, uint16(0), uint16(14), make([]byte, 16)))
+		g.Write(biff(biffBoundSheet, sheetPos, byte(0), byte(0), byte(len(sheetName)), biffChars(sheetName)))
+		g.Write(biff(biffSST, sstData...))
+		g.Write(biff(biffEOF))
+		return g.Bytes()
+	}
+	stream := globals(uint32(len(globals(0))))
+	return append(stream, sheet.Bytes()...)
+}
+
+// a compound file holding stream as its Workbook stream, as saved by excel 97 and later
+func mkXLS(stream []byte) []byte {
+	const sectorSize = 512
+	// streams smaller than this are kept in the mini stream, which the file does not need then
+	if len(stream) < 4096 {
+		stream = append(stream, make([]byte, 4096-len(stream))...)
+	}
+	streamSectors := (len(stream) + sectorSize - 1) / sectorSize
+	le := binary.LittleEndian
+
+	header := make([]byte, sectorSize)
+	copy(header, cfbSignature)
+	le.PutUint16(header[0x18:], 0x3E)
+	le.PutUint16(header[0x1A:], 3)
+	le.PutUint16(header[0x1C:], 0xFFFE)
+	le.PutUint16(header[0x1E:], 9) // 512 byte sectors
+	le.PutUint16(header[0x20:], 6) // 64 byte mini sectors
+	le.PutUint32(header[0x2C:], 1) // one FAT sector
+	le.PutUint32(header[0x30:], 1) // directory in sector 1
+	le.PutUint32(header[0x38:], 4096)
+	le.PutUint32(header[0x3C:], cfbEndOfChain) // no mini FAT
+	le.PutUint32(header[0x44:], cfbEndOfChain) // no DIFAT sectors
+	for i := 0; i < 109; i++ {
+		le.PutUint32(header[0x4C+4*i:], cfbFreeSect)
+	}
+	le.PutUint32(header[0x4C:], 0) // the FAT is sector 0
+
+	fat := make([]byte, sectorSize)
+	for i := 0; i < sectorSize/4; i++ {
+		le.PutUint32(fat[4*i:], cfbFreeSect)
+	}
+	le.PutUint32(fat[0:], 0xFFFFFFFD) // the FAT itself
+	le.PutUint32(fat[4:], cfbEndOfChain)
+	for i := 0; i < streamSectors; i++ {
+		next := uint32(3 + i)
+		if i == streamSectors-1 {
+			next = cfbEndOfChain
+		}
+		le.PutUint32(fat[4*(2+i):], next)
+	}
+
+	dir := make([]byte, sectorSize)
+	dirEntry := func(entry []byte, name string, typ byte, start uint32, size int) {
+		units := utf16.Encode([]rune(name))
+		for i, u := range units {
+			le.PutUint16(entry[2*i:], u)
+		}
+		le.PutUint16(entry[0x40:], uint16(2*len(units)+2))
+		entry[0x42] = typ
+		le.PutUint32(entry[0x44:], cfbFreeSect)
+		le.PutUint32(entry[0x48:], cfbFreeSect)
+		le.PutUint32(entry[0x4C:], cfbFreeSect)
+		le.PutUint32(entry[0x74:], start)
+		le.PutUint32(entry[0x78:], uint32(size))
+	}
+	dirEntry(dir[0:], "Root Entry", 5, cfbEndOfChain, 0)
+	le.PutUint32(dir[0x4C:], 1) // the root's child is the workbook stream
+	dirEntry(dir[cfbDirEntry:], "Workbook", 2, 2, len(stream))
+
+	file := append(append(append(header, fat...), dir...), stream...)
+	return append(file, make([]byte, streamSectors*sectorSize-len(stream))...)
+}
+
+type xlsApple struct {
+	Variety string    `xtg:"col:Variety"`
+	Weight  float64   `xtg:"col:Weight"`
+	Picked  time.Time `xtg:"col:Picked"`
+	Organic bool      `xtg:"col:Organic"`
+}
+
+func xlsAppleBook() []byte {
+	return mkXLS(xlsStream("Apples", []xlsCell{
+		{0, 0, "Variety"}, {0, 1, "Weight"}, {0, 2, "Picked"}, {0, 3, "Organic"},
+		{1, 0, "Gala"}, {1, 1, 3.5}, {1, 2, xlsDate(44000)}, {1, 3, true},
+		{2, 0, xlsFormulaText("Fuji")}, {2, 1, 4}, {2, 2, xlsDate(44001.5)}, {2, 3, false},
+	}))
+}
+
+func TestXLS(t *testing.T) {
+	out, err := BytesToSlice(xlsAppleBook(), "Apples", &xlsApple{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := out.([]xlsApple)
+	want := []xlsApple{
+		{"Gala", 3.5, time.Date(2020, 6, 18, 0, 0, 0, 0, time.UTC), true},
+		{"Fuji", 4, time.Date(2020, 6, 19, 12, 0, 0, 0, time.UTC), false},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].Variety != want[i].Variety || got[i].Weight != want[i].Weight || !got[i].Picked.Equal(want[i].Picked) || got[i].Organic != want[i].Organic {
+			t.Errorf("record %v: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestXLSFileSheetsToSlice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apples.xls")
+	if err := os.WriteFile(path, xlsAppleBook(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ExcelFileSheetsToSlice(path, "^Apples$", &xlsApple{}, Params{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(out.([]xlsApple)); n != 2 {
+		t.Errorf("got %v records, want 2", n)
+	}
+}
+
+func TestRKValue(t *testing.T) {
+	tests := []struct {
+		rk   uint32
+		want float64
+	}{
+		{4<<2 | 2, 4},
+		{0xFFFFFFE6, -7},
+		{1234<<2 | 3, 12.34},
+		{uint32(math.Float64bits(2.5) >> 32), 2.5},
+	}
+	for _, tt := range tests {
+		if got := rkValue(tt.rk); got != tt.want {
+			t.Errorf("rkValue(%#x) = %v, want %v", tt.rk, got, tt.want)
+		}
+	}
+}
+
+func TestUnsupportedXLS(t *testing.T) {
+	biff5 := mkXLS(biff(biffBOF, uint16(0x0500), uint16(0x0005)))
+	if _, err := parseXLS(biff5); err == nil {
+		t.Error("expected an error for a BIFF5 workbook")
+	}
+	encrypted := mkXLS(append(biff(biffBOF, uint16(0x0600), uint16(0x0005)), biff(biffFilePass, uint16(1))...))
+	if _, err := parseXLS(encrypted); err == nil {
+		t.Error("expected an error for an encrypted workbook")
+	}
+	if _, err := parseCFB(xlsAppleBook()[:100]); err == nil {
+		t.Error("expected an error for a truncated compound file")
+	}
+}